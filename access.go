@@ -0,0 +1,137 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+)
+
+// actions of AccessRule
+const (
+	AccessAllow = "allow"
+	AccessDeny  = "deny"
+)
+
+// listName matches valid name of AddressList
+var listName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// AddressList is a named list of client address ranges, referred by access
+// rules
+type AddressList struct {
+	Name  string   `json:"name"`
+	CIDRs []string `json:"cidrs"`
+}
+
+// Validate checks if l is malformed
+func (l *AddressList) Validate() error {
+	if !listName.MatchString(l.Name) {
+		return fmt.Errorf("invalid list name %q", l.Name)
+	}
+	if len(l.CIDRs) == 0 {
+		return fmt.Errorf("list %s is empty", l.Name)
+	}
+	for _, cidr := range l.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid address range %s", cidr)
+		}
+	}
+	return nil
+}
+
+// AccessRule allows or denies clients in an address range, or in an
+// AddressList
+type AccessRule struct {
+	Action string `json:"action"`
+	CIDR   string `json:"cidr,omitempty"`
+	List   string `json:"list,omitempty"` // name of AddressList, instead of CIDR
+}
+
+// Access controls which clients can access a server or mapping. Rules are
+// checked in order, Default applies if no rule matches.
+type Access struct {
+	Rules   []*AccessRule `json:"rules,omitempty"`
+	Default string        `json:"default"`
+}
+
+// Validate checks if a is malformed
+func (a *Access) Validate() error {
+	if a.Default != AccessAllow && a.Default != AccessDeny {
+		return fmt.Errorf("access default should be allow or deny")
+	}
+
+	for _, r := range a.Rules {
+		if r.Action != AccessAllow && r.Action != AccessDeny {
+			return fmt.Errorf("access action should be allow or deny")
+		}
+		if (r.CIDR == "") == (r.List == "") {
+			return fmt.Errorf("access rule needs either cidr or list")
+		}
+		if r.List != "" && !listName.MatchString(r.List) {
+			return fmt.Errorf("invalid list name %q", r.List)
+		}
+		if r.CIDR == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(r.CIDR); err != nil {
+			return fmt.Errorf("invalid address range %s", r.CIDR)
+		}
+	}
+	return nil
+}
+
+// SetAccess restricts client addresses of s with a, or removes restriction
+// if a is nil
+func (s *NginxServer) SetAccess(a *Access) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Access = a
+}
+
+// lists returns names of AddressList referred by a
+func (a *Access) lists() []string {
+	var ret []string
+	for _, r := range a.Rules {
+		if r.List != "" {
+			ret = append(ret, r.List)
+		}
+	}
+	return ret
+}
+
+// accessDirective is an allow or deny directive
+type accessDirective struct {
+	Action string
+	Source string
+}
+
+// directives renders a with address lists, unknown lists deny all clients
+func (a *Access) directives(lists map[string]*AddressList) []*accessDirective {
+	ret := make([]*accessDirective, 0, len(a.Rules)+1)
+	for _, r := range a.Rules {
+		if r.CIDR != "" {
+			ret = append(ret, &accessDirective{r.Action, r.CIDR})
+			continue
+		}
+
+		l, ok := lists[r.List]
+		if !ok {
+			return append(ret, &accessDirective{AccessDeny, "all"})
+		}
+		for _, cidr := range l.CIDRs {
+			ret = append(ret, &accessDirective{r.Action, cidr})
+		}
+	}
+
+	return append(ret, &accessDirective{a.Default, "all"})
+}
+
+// byListName sorts address lists by name
+type byListName []*AddressList
+
+func (s byListName) Len() int           { return len(s) }
+func (s byListName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s byListName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
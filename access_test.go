@@ -0,0 +1,140 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestAccessValidate(t *testing.T) {
+	valid := []*Access{
+		{Default: AccessAllow},
+		{Rules: []*AccessRule{{Action: AccessAllow, CIDR: "10.8.0.0/16"}, {Action: AccessAllow, CIDR: "fd00::/8"}}, Default: AccessDeny},
+		{Rules: []*AccessRule{{Action: AccessDeny, List: "office"}}, Default: AccessAllow},
+	}
+	for _, a := range valid {
+		if err := a.Validate(); err != nil {
+			t.Errorf("Valid access %#v is rejected: %s", a, err)
+		}
+	}
+
+	invalid := []*Access{
+		{},
+		{Default: "drop"},
+		{Rules: []*AccessRule{{Action: "permit", CIDR: "10.0.0.0/8"}}, Default: AccessDeny},
+		{Rules: []*AccessRule{{Action: AccessAllow, CIDR: "10.0.0.1"}}, Default: AccessDeny},
+		{Rules: []*AccessRule{{Action: AccessAllow, CIDR: "10.0.0.0/33"}}, Default: AccessDeny},
+		{Rules: []*AccessRule{{Action: AccessAllow}}, Default: AccessDeny},
+		{Rules: []*AccessRule{{Action: AccessAllow, CIDR: "10.0.0.0/8", List: "office"}}, Default: AccessDeny},
+		{Rules: []*AccessRule{{Action: AccessAllow, List: "../office"}}, Default: AccessDeny},
+	}
+	for _, a := range invalid {
+		if a.Validate() == nil {
+			t.Errorf("Invalid access %#v is accepted", a)
+		}
+	}
+
+	for _, l := range []*AddressList{
+		{Name: "office"},
+		{Name: "a b", CIDRs: []string{"10.0.0.0/8"}},
+		{Name: "office", CIDRs: []string{"10.0.0.0"}},
+	} {
+		if l.Validate() == nil {
+			t.Errorf("Invalid address list %#v is accepted", l)
+		}
+	}
+}
+
+func TestAccessExport(t *testing.T) {
+	expect := `server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 80;
+    deny 10.0.0.13/32;
+    allow 10.0.0.0/8;
+    deny all;
+
+    location /admin/ {
+        deny all;
+        proxy_pass http://admin;
+        include proxy_params;
+        
+    }
+
+    location /public/ {
+        allow all;
+        proxy_pass http://public;
+        include proxy_params;
+        
+    }
+
+}`
+
+	s := NewServer("example.com")
+	s.SetAccess(&Access{
+		Rules: []*AccessRule{
+			{Action: AccessDeny, CIDR: "10.0.0.13/32"},
+			{Action: AccessAllow, CIDR: "10.0.0.0/8"},
+		},
+		Default: AccessDeny,
+	})
+	s.CreateMapping("/public/", &Mapping{Upstream: "http://public", Access: &Access{Default: AccessAllow}})
+	// unknown list, nobody is allowed
+	s.CreateMapping("/admin/", &Mapping{Upstream: "http://admin", Access: &Access{
+		Rules:   []*AccessRule{{Action: AccessAllow, List: "office"}},
+		Default: AccessAllow,
+	}})
+	if actual := s.Export(); actual != expect {
+		t.Errorf("Access returns %s", actual)
+	}
+}
+
+func TestAccessPersistor(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	p.CreateMapping("test.server", "/", &Mapping{Upstream: "http://a"})
+	access := &Access{Rules: []*AccessRule{{Action: AccessAllow, List: "office"}}, Default: AccessDeny}
+	if _, err := p.SetAccess("test.server", access); err != ErrNoSuchList {
+		t.Errorf("Unknown list is accepted, got %v", err)
+	}
+	if _, err := p.CreateMapping("test.server", "/a", &Mapping{Upstream: "http://a", Access: access}); err != ErrNoSuchList {
+		t.Errorf("Unknown list of new mapping is accepted, got %v", err)
+	}
+	if _, err := p.ModifyMapping("test.server", "/", "/", &Mapping{Upstream: "http://a", Access: access}); err != ErrNoSuchList {
+		t.Errorf("Unknown list of modified mapping is accepted, got %v", err)
+	}
+
+	if err := p.SaveAddressList(&AddressList{Name: "office", CIDRs: []string{"192.168.0.0/24", "10.8.0.0/16"}}); err != nil {
+		t.Fatalf("Cannot save address list: %s", err)
+	}
+	if _, err := p.SetAccess("test.server", access); err != nil {
+		t.Fatalf("Cannot set access of server: %s", err)
+	}
+
+	conf, _ := ioutil.ReadFile(p.conffile)
+	expect := "    allow 192.168.0.0/24;\n    allow 10.8.0.0/16;\n    deny all;\n"
+	if !bytes.Contains(conf, []byte(expect)) {
+		t.Errorf("Address list is not expanded:\n%s", conf)
+	}
+
+	if err := p.DeleteAddressList("office"); err != ErrListInUse {
+		t.Errorf("Address list in use is deleted, got %v", err)
+	}
+	p.SetAccess("test.server", nil)
+	if err := p.DeleteAddressList("office"); err != nil {
+		t.Errorf("Cannot delete address list: %s", err)
+	}
+	if err := p.DeleteAddressList("office"); err != ErrNoSuchList {
+		t.Errorf("Unknown address list is deleted, got %v", err)
+	}
+
+	conf, _ = ioutil.ReadFile(p.conffile)
+	if strings.Contains(string(conf), "deny all;") {
+		t.Errorf("Access is not removed:\n%s", conf)
+	}
+}
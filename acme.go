@@ -0,0 +1,236 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// LetsEncryptURL is directory url of Let's Encrypt production CA
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// acmeTimeout limits time spent on issuing one certificate
+const acmeTimeout = 5 * time.Minute
+
+// ACMEManager issues certificates from an ACME CA with HTTP-01 challenge.
+//
+// Challenge responses are written into a directory, which is served by nginx
+// with a location injected into generated config.
+type ACMEManager struct {
+	dir       string
+	challenge string
+	email     string
+	client    *acme.Client
+	sync.Mutex
+}
+
+// OpenACME creates an ACMEManager storing account key and certificates in
+// dir, and challenge responses in challenge. Account key is created if not
+// exist.
+func OpenACME(dir, challenge, directory, email string) (*ACMEManager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(challenge, 0755); err != nil {
+		return nil, err
+	}
+
+	keyFile := filepath.Join(dir, "account.key")
+	if _, err := os.Stat(keyFile); os.IsNotExist(err) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		if err = writeKey(keyFile, key); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := loadKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ACMEManager{
+		dir:       dir,
+		challenge: challenge,
+		email:     email,
+		client:    &acme.Client{Key: key, DirectoryURL: directory},
+	}, nil
+}
+
+// Paths returns path to certificate and key of host
+func (m *ACMEManager) Paths(host string) (cert, key string) {
+	base := filepath.Join(m.dir, certName(host))
+	return base + ".crt", base + ".key"
+}
+
+// ChallengeDir returns the directory holding challenge responses
+func (m *ACMEManager) ChallengeDir() string {
+	return m.challenge
+}
+
+// Valid reports whether certificate of hosts exists, covers all of them and
+// is not expiring
+func (m *ACMEManager) Valid(hosts ...string) bool {
+	if len(hosts) == 0 {
+		return false
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	leaf, _, err := loadKeyPair(m.Paths(hosts[0]))
+	return err == nil && !needRenew(leaf) && covers(leaf, hosts)
+}
+
+// Issue orders a certificate of hosts from ACME CA, first host is used as
+// common name. Nginx must be serving ChallengeDir for all hosts before calling
+// it.
+func (m *ACMEManager) Issue(hosts ...string) (cert, key string, err error) {
+	if len(hosts) == 0 || hosts[0] == "" {
+		return "", "", errors.New("cannot issue certificate without server name")
+	}
+	host := hosts[0]
+
+	m.Lock()
+	defer m.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), acmeTimeout)
+	defer cancel()
+
+	if err = m.register(ctx); err != nil {
+		return
+	}
+
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(hosts...))
+	if err != nil {
+		return
+	}
+	uri := order.URI
+	for _, u := range order.AuthzURLs {
+		if err = m.authorize(ctx, u); err != nil {
+			return
+		}
+	}
+	if order, err = m.client.WaitOrder(ctx, uri); err != nil {
+		return
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: host},
+		DNSNames: hosts,
+	}, priv)
+	if err != nil {
+		return
+	}
+
+	chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		// some CAs omit order url when finalizing, so wait for it ourselves
+		done, e := m.client.WaitOrder(ctx, uri)
+		if e != nil || done.CertURL == "" {
+			return
+		}
+		if chain, err = m.client.FetchCert(ctx, done.CertURL, true); err != nil {
+			return
+		}
+	}
+
+	cert, key = m.Paths(host)
+	err = writeKeyPair(cert, key, chain, priv)
+	return
+}
+
+// register creates account if not registered yet
+func (m *ACMEManager) register(ctx context.Context) error {
+	acct := &acme.Account{}
+	if m.email != "" {
+		acct.Contact = []string{"mailto:" + m.email}
+	}
+
+	_, err := m.client.Register(ctx, acct, acme.AcceptTOS)
+	if err == acme.ErrAccountAlreadyExists {
+		return nil
+	}
+	return err
+}
+
+// authorize fulfills HTTP-01 challenge of an authorization
+func (m *ACMEManager) authorize(ctx context.Context, url string) error {
+	z, err := m.client.GetAuthorization(ctx, url)
+	if err != nil {
+		return err
+	}
+	if z.Status == acme.StatusValid {
+		return nil
+	}
+
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == "http-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return errors.New("CA does not offer http-01 challenge for " + z.Identifier.Value)
+	}
+
+	resp, err := m.client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		return err
+	}
+	fn := filepath.Join(m.challenge, chal.Token)
+	if err = ioutil.WriteFile(fn, []byte(resp), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(fn)
+
+	if _, err = m.client.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = m.client.WaitAuthorization(ctx, z.URI)
+	return err
+}
+
+func writeKey(keyFile string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+}
+
+func loadKey(keyFile string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no private key found in " + keyFile)
+	}
+
+	return x509.ParseECPrivateKey(block.Bytes)
+}
@@ -0,0 +1,167 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestACMEExport(t *testing.T) {
+	pending := `server {
+    server_name example.com;
+    listen 80;
+
+    location ^~ /.well-known/acme-challenge/ {
+        default_type text/plain;
+        alias /var/lib/yeast/acme-challenge/;
+    }
+}
+`
+	ready := `server {
+    server_name example.com;
+    listen 80;
+
+    location ^~ /.well-known/acme-challenge/ {
+        default_type text/plain;
+        alias /var/lib/yeast/acme-challenge/;
+    }
+
+    location / {
+        return 301 https://$host$request_uri;
+    }
+}
+
+server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 443 ssl http2;
+    ssl_certificate ` + os.Args[0] + `;
+    ssl_certificate_key /var/lib/yeast/certs/acme/example.com.key;
+
+}`
+
+	s := NewServer("example.com")
+	s.SetTLS(&TLSConfig{
+		Source:      TLSSourceACME,
+		Certificate: "/var/lib/yeast/certs/acme/example.com.crt",
+		Key:         "/var/lib/yeast/certs/acme/example.com.key",
+		Challenge:   "/var/lib/yeast/acme-challenge",
+	})
+	if actual := s.Export(); actual != pending {
+		t.Errorf("Pending ACME server returns %s", actual)
+	}
+
+	// any existing file is fine
+	s.TLS.Certificate = os.Args[0]
+	s.TLS.Redirect = true
+	if actual := s.Export(); actual != ready {
+		t.Errorf("ACME server returns %s", actual)
+	}
+}
+
+func TestACMEPersistor(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+	dir, err := ioutil.TempDir("", "acme")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := OpenACME(filepath.Join(dir, "acme"), filepath.Join(dir, "challenge"), "http://127.0.0.1:1/dir", "")
+	if err != nil {
+		t.Fatalf("Cannot open ACME manager: %s", err)
+	}
+	p.SetIssuer(TLSSourceACME, m)
+	p.Create("example.com", "/", "http://upstream", "")
+
+	srv, err := p.SetTLS("example.com", &TLSConfig{Source: TLSSourceACME})
+	if err != nil {
+		t.Fatalf("Cannot use ACME certificate: %s", err)
+	}
+	cert, key := m.Paths("example.com")
+	if srv.TLS.Certificate != cert || srv.TLS.Key != key || srv.TLS.Challenge != m.ChallengeDir() {
+		t.Errorf("Paths are not managed by ACME manager: %#v", srv.TLS)
+	}
+
+	keyFile := filepath.Join(dir, "acme", "account.key")
+	before, _ := ioutil.ReadFile(keyFile)
+	if _, err := OpenACME(filepath.Join(dir, "acme"), filepath.Join(dir, "challenge"), "", ""); err != nil {
+		t.Errorf("Cannot reopen ACME manager: %s", err)
+	}
+	if after, _ := ioutil.ReadFile(keyFile); string(after) != string(before) {
+		t.Error("Account key is recreated when reopening")
+	}
+}
+
+// TestACMEPebble issues a certificate from a local ACME server such as
+// Pebble. It is skipped unless YEAST_TEST_ACME_DIRECTORY is set.
+//
+// YEAST_TEST_ACME_CA is the certificate used by the ACME server for https,
+// YEAST_TEST_ACME_HOST (default localhost) is the name to issue, and
+// YEAST_TEST_ACME_ADDR (default :5002) is where the ACME server validates
+// HTTP-01 challenges.
+func TestACMEPebble(t *testing.T) {
+	directory := os.Getenv("YEAST_TEST_ACME_DIRECTORY")
+	if directory == "" {
+		t.Skip("YEAST_TEST_ACME_DIRECTORY is not set")
+	}
+	host := os.Getenv("YEAST_TEST_ACME_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+	addr := os.Getenv("YEAST_TEST_ACME_ADDR")
+	if addr == "" {
+		addr = ":5002"
+	}
+
+	dir, err := ioutil.TempDir("", "acme")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := OpenACME(filepath.Join(dir, "acme"), filepath.Join(dir, "challenge"), directory, "test@example.com")
+	if err != nil {
+		t.Fatalf("Cannot open ACME manager: %s", err)
+	}
+	if fn := os.Getenv("YEAST_TEST_ACME_CA"); fn != "" {
+		pem, err := ioutil.ReadFile(fn)
+		if err != nil {
+			t.Fatalf("Cannot read ACME server certificate: %s", err)
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(pem)
+		m.client.HTTPClient = &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}}
+	}
+
+	// stand-in of nginx serving challenge responses
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Cannot listen on %s: %s", addr, err)
+	}
+	defer l.Close()
+	fs := http.StripPrefix("/.well-known/acme-challenge/", http.FileServer(http.Dir(m.ChallengeDir())))
+	go http.Serve(l, fs)
+
+	cert, key, err := m.Issue(host)
+	if err != nil {
+		t.Fatalf("Cannot issue certificate: %s", err)
+	}
+	if _, err := tls.LoadX509KeyPair(cert, key); err != nil {
+		t.Errorf("Issued key pair is invalid: %s", err)
+	}
+	if !m.Valid(host) {
+		t.Error("Just issued certificate should be valid")
+	}
+}
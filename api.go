@@ -5,13 +5,135 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 // Handler handles all api calls
 type Handler struct {
 	Persistor   *Persistor
 	ReloadNginx func() bool // reload nginx, return true if success
+	CA          *LocalCA    // local CA, nil if not available
+}
+
+// mappingFromForm reads mapping fields from posted form, prefix is prepended to
+// every field name. The mapping is validated against path.
+func mappingFromForm(r *http.Request, prefix, path string) (*Mapping, error) {
+	m := &Mapping{
+		Type:        r.PostFormValue(prefix + "type"),
+		Upstream:    r.PostFormValue(prefix + "upstream"),
+		Pool:        r.PostFormValue(prefix + "pool"),
+		Protocol:    r.PostFormValue(prefix + "protocol"),
+		ScriptRoot:  r.PostFormValue(prefix + "script_root"),
+		CustomTags:  r.PostFormValue(prefix + "custom_tags"),
+		Match:       r.PostFormValue(prefix + "match"),
+		Auth:        r.PostFormValue(prefix + "auth"),
+		Description: r.PostFormValue(prefix + "description"),
+		Owner:       r.PostFormValue(prefix + "owner"),
+	}
+
+	m.WebSocket, _ = strconv.ParseBool(r.PostFormValue(prefix + "websocket"))
+	m.Streaming, _ = strconv.ParseBool(r.PostFormValue(prefix + "streaming"))
+	m.ReadTimeout = r.PostFormValue(prefix + "read_timeout")
+	m.Order, _ = strconv.Atoi(r.PostFormValue(prefix + "order"))
+
+	if labels := r.PostFormValue(prefix + "labels"); labels != "" {
+		if err := json.Unmarshal([]byte(labels), &m.Labels); err != nil {
+			return nil, errors.New(prefix + "labels must be a json object of strings")
+		}
+	}
+
+	if secrets := r.PostFormValue(prefix + "secrets"); secrets != "" {
+		if err := json.Unmarshal([]byte(secrets), &m.Secrets); err != nil {
+			return nil, errors.New(prefix + "secrets must be a json object of strings")
+		}
+	}
+
+	if err := jsonFormValue(r, prefix+"tuning", &m.Tuning); err != nil {
+		return nil, err
+	}
+	if err := jsonFormValue(r, prefix+"headers", &m.Headers); err != nil {
+		return nil, err
+	}
+	if err := jsonFormValue(r, prefix+"access", &m.Access); err != nil {
+		return nil, err
+	}
+	if err := jsonFormValue(r, prefix+"rate_limit", &m.RateLimit); err != nil {
+		return nil, err
+	}
+	if err := jsonFormValue(r, prefix+"cache", &m.Cache); err != nil {
+		return nil, err
+	}
+	if err := jsonFormValue(r, prefix+"cors", &m.CORS); err != nil {
+		return nil, err
+	}
+	if err := jsonFormValue(r, prefix+"overrides", &m.Overrides); err != nil {
+		return nil, err
+	}
+	if err := jsonFormValue(r, prefix+"rewrite", &m.Rewrite); err != nil {
+		return nil, err
+	}
+	if err := jsonFormValue(r, prefix+"redirect", &m.Redirect); err != nil {
+		return nil, err
+	}
+	if err := jsonFormValue(r, prefix+"response", &m.Response); err != nil {
+		return nil, err
+	}
+	if err := jsonFormValue(r, prefix+"static", &m.Static); err != nil {
+		return nil, err
+	}
+
+	return m, m.Validate(path)
+}
+
+// jsonFormValue decodes json object in form field name into v, empty value
+// and null are ignored.
+func jsonFormValue(r *http.Request, name string, v interface{}) error {
+	val := r.PostFormValue(name)
+	if val == "" || val == "null" {
+		return nil
+	}
+
+	if err := json.Unmarshal([]byte(val), v); err != nil {
+		return errors.New(name + " must be a json object")
+	}
+	return nil
+}
+
+// maskedPaths returns mappings of srv with secret values hidden
+func maskedPaths(srv *NginxServer) map[string]*Mapping {
+	ret := srv.List()
+	for path, mapping := range ret {
+		ret[path] = mapping.masked()
+	}
+	return ret
+}
+
+// serverSettings is server level settings in api response
+type serverSettings struct {
+	Names   []string   `json:"names,omitempty"`
+	Listens []*Listen  `json:"listens,omitempty"`
+	TLS     *TLSConfig `json:"tls,omitempty"`
+	Auth    string     `json:"auth,omitempty"`
+	Access  *Access    `json:"access,omitempty"`
+	Tuning  *Tuning    `json:"tuning,omitempty"`
+}
+
+func settingsOf(srv *NginxServer) *serverSettings {
+	srv.RLock()
+	defer srv.RUnlock()
+
+	return &serverSettings{
+		Names:   srv.Names,
+		Listens: srv.Listens,
+		TLS:     srv.TLS,
+		Auth:    srv.Auth,
+		Access:  srv.Access,
+		Tuning:  srv.Tuning,
+	}
 }
 
 // List lists all known mapping data
@@ -19,7 +141,7 @@ func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
 	res := h.Persistor.List()
 	data := map[string]map[string]*Mapping{}
 	for k, v := range res {
-		data[k] = v.Paths
+		data[k] = maskedPaths(v)
 	}
 	buf, err := json.Marshal(data)
 	if err != nil {
@@ -37,23 +159,60 @@ func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
 
 	name := r.PostFormValue("name")
 	path := r.PostFormValue("path")
-	upstream := r.PostFormValue("upstream")
-	custom := r.PostFormValue("custom_tags")
+	mapping, err := mappingFromForm(r, "", path)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if name == "" || path == "" || (mapping.proxied() && mapping.Upstream == "" && mapping.Pool == "") {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("you must pass at least name, path and upstream or pool"))
+		return
+	}
+
+	if mapping.Pool != "" && !h.Persistor.HasPool(mapping.Pool) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("no such pool " + mapping.Pool))
+		return
+	}
+
+	if mapping.Auth != "" && mapping.Auth != AuthOff && !h.Persistor.HasCredentials(mapping.Auth) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("no such credentials " + mapping.Auth))
+		return
+	}
 
-	if name == "" || path == "" || upstream == "" {
+	if err := h.Persistor.Seal(mapping); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("you must pass at least name, path and upstream"))
+		w.Write([]byte(err.Error()))
 		return
 	}
 
-	res := h.Persistor.Create(name, path, upstream, custom)
+	res, err := h.Persistor.CreateMapping(name, path, mapping)
+	switch err {
+	case nil:
+	case ErrPortInUse, ErrSharedH2C:
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	case ErrNoSuchList, ErrNoSuchPool, ErrMaskedSecret:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if res == nil {
 		w.WriteHeader(http.StatusConflict)
 		return
 	}
 
 	data := map[string]map[string]*Mapping{
-		res.ServerName: res.Paths,
+		res.ServerName: maskedPaths(res),
 	}
 	buf, err := json.Marshal(data)
 	if err != nil {
@@ -78,23 +237,60 @@ func (h *Handler) Modify(w http.ResponseWriter, r *http.Request) {
 	name := r.PostFormValue("name")
 	path := r.PostFormValue("path")
 	newPath := r.PostFormValue("new_path")
-	upstream := r.PostFormValue("new_upstream")
-	custom := r.PostFormValue("new_custom_tags")
+	mapping, err := mappingFromForm(r, "new_", newPath)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if name == "" || path == "" || newPath == "" || (mapping.proxied() && mapping.Upstream == "" && mapping.Pool == "") {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("you must pass at least name, path, new_path and new_upstream or new_pool"))
+		return
+	}
+
+	if mapping.Pool != "" && !h.Persistor.HasPool(mapping.Pool) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("no such pool " + mapping.Pool))
+		return
+	}
+
+	if mapping.Auth != "" && mapping.Auth != AuthOff && !h.Persistor.HasCredentials(mapping.Auth) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("no such credentials " + mapping.Auth))
+		return
+	}
 
-	if name == "" || path == "" || newPath == "" || upstream == "" {
+	if err := h.Persistor.Seal(mapping); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("you must pass at least name, path, new_path and new_upstream"))
+		w.Write([]byte(err.Error()))
 		return
 	}
 
-	res := h.Persistor.Modify(name, path, newPath, upstream, custom)
+	res, err := h.Persistor.ModifyMapping(name, path, newPath, mapping)
+	switch err {
+	case nil:
+	case ErrSharedH2C:
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	case ErrNoSuchList, ErrNoSuchPool, ErrMaskedSecret:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if res == nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
 	data := map[string]map[string]*Mapping{
-		res.ServerName: res.Paths,
+		res.ServerName: maskedPaths(res),
 	}
 	buf, err := json.Marshal(data)
 	if err != nil {
@@ -125,7 +321,12 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res := h.Persistor.Delete(name, path)
+	res, err := h.Persistor.Delete(name, path)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if res == nil {
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("No such data"))
@@ -139,7 +340,7 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := map[string]map[string]*Mapping{
-		res.ServerName: res.Paths,
+		res.ServerName: maskedPaths(res),
 	}
 	buf, err := json.Marshal(data)
 	if err != nil {
@@ -157,11 +358,16 @@ func (h *Handler) Enable(w http.ResponseWriter, r *http.Request) {
 	name := r.PostFormValue("name")
 	path := r.PostFormValue("path")
 
-	res := h.Persistor.Enable(name, path)
+	res, err := h.Persistor.Enable(name, path)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
 
 	data := map[string]map[string]*Mapping{}
 	for k, v := range res {
-		data[k] = v.Paths
+		data[k] = maskedPaths(v)
 	}
 	buf, err := json.Marshal(data)
 	if err != nil {
@@ -186,11 +392,820 @@ func (h *Handler) Disable(w http.ResponseWriter, r *http.Request) {
 	name := r.PostFormValue("name")
 	path := r.PostFormValue("path")
 
-	res := h.Persistor.Disable(name, path)
+	res, err := h.Persistor.Disable(name, path)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
 
 	data := map[string]map[string]*Mapping{}
 	for k, v := range res {
-		data[k] = v.Paths
+		data[k] = maskedPaths(v)
+	}
+	buf, err := json.Marshal(data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot serialize data to json format."))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	w.Write(buf)
+}
+
+// Servers lists server level settings of all known servers
+func (h *Handler) Servers(w http.ResponseWriter, r *http.Request) {
+	res := h.Persistor.List()
+	data := map[string]*serverSettings{}
+	for k, v := range res {
+		data[k] = settingsOf(v)
+	}
+	buf, err := json.Marshal(data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot serialize data to json format."))
+		return
+	}
+
+	w.Write(buf)
+}
+
+// TLS sets https settings of a server
+func (h *Handler) TLS(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	name := r.PostFormValue("name")
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("you must pass at least name"))
+		return
+	}
+
+	var conf *TLSConfig
+	source := r.PostFormValue("source")
+	cert := r.PostFormValue("certificate")
+	key := r.PostFormValue("key")
+	if source != "" || cert != "" || key != "" {
+		redirect, _ := strconv.ParseBool(r.PostFormValue("redirect"))
+		conf = &TLSConfig{
+			Source:      source,
+			Certificate: cert,
+			Key:         key,
+			Protocols:   strings.Fields(r.PostFormValue("protocols")),
+			Redirect:    redirect,
+		}
+		if err := conf.Validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	res, err := h.Persistor.SetTLS(name, conf)
+	switch err {
+	case nil:
+	case ErrPortInUse, ErrDefaultServer, ErrSharedH2C:
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if res == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("No such server"))
+		return
+	}
+
+	data := map[string]*serverSettings{
+		res.ServerName: settingsOf(res),
+	}
+	buf, err := json.Marshal(data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot serialize data to json format."))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	if conf != nil && conf.Source != TLSSourceFile {
+		// nginx must be serving challenges before issuing
+		go h.renewCertificates()
+	}
+	w.Write(buf)
+}
+
+// renewCertificates renews certificates managed by Yeast, and reloads nginx
+// if needed.
+func (h *Handler) renewCertificates() {
+	renewed, err := h.Persistor.RenewCertificates()
+	if err != nil {
+		log.Printf("Cannot renew certificates: %s", err)
+	}
+	if renewed && !h.ReloadNginx() {
+		log.Print("Cannot reload Nginx after renewing certificates")
+	}
+}
+
+// CACert sends certificate of local CA, so users can add it to trust store
+func (h *Handler) CACert(w http.ResponseWriter, r *http.Request) {
+	if h.CA == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Local CA is not available"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Header().Set("Content-Disposition", `attachment; filename="yeast-ca.crt"`)
+	http.ServeFile(w, r, h.CA.CertFile())
+}
+
+// Pools lists all upstream pools
+func (h *Handler) Pools(w http.ResponseWriter, r *http.Request) {
+	buf, err := json.Marshal(h.Persistor.Pools())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot serialize data to json format."))
+		return
+	}
+
+	w.Write(buf)
+}
+
+// SavePool creates or replaces an upstream pool
+func (h *Handler) SavePool(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	pool := &Pool{
+		Name:    r.PostFormValue("name"),
+		Method:  r.PostFormValue("method"),
+		HashKey: r.PostFormValue("hash_key"),
+	}
+	pool.Consistent, _ = strconv.ParseBool(r.PostFormValue("consistent"))
+	if err := json.Unmarshal([]byte(r.PostFormValue("servers")), &pool.Servers); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("servers must be a json array of servers"))
+		return
+	}
+	if err := pool.Validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if err := h.Persistor.SavePool(pool); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	h.Pools(w, r)
+}
+
+// DeletePool deletes an upstream pool not used by any mapping
+func (h *Handler) DeletePool(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	switch err := h.Persistor.DeletePool(r.PostFormValue("name")); err {
+	case nil:
+	case ErrNoSuchPool:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	case ErrPoolInUse:
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	h.Pools(w, r)
+}
+
+// PoolMember marks a server in upstream pool as down or up, mappings using
+// the pool are not touched.
+func (h *Handler) PoolMember(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	down, err := strconv.ParseBool(r.PostFormValue("down"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("you must pass name, address and down"))
+		return
+	}
+
+	res, err := h.Persistor.SetPoolMember(r.PostFormValue("name"), r.PostFormValue("address"), down)
+	switch err {
+	case nil:
+	case ErrNoSuchPool, ErrNoSuchMember:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	buf, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot serialize data to json format."))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	w.Write(buf)
+}
+
+// credentialsView is credential set in api response, passwords are omitted
+type credentialsView struct {
+	Name  string   `json:"name"`
+	Users []string `json:"users"`
+}
+
+// Credentials lists all credential sets and their users
+func (h *Handler) Credentials(w http.ResponseWriter, r *http.Request) {
+	sets := h.Persistor.Credentials()
+	data := make([]*credentialsView, 0, len(sets))
+	for _, c := range sets {
+		data = append(data, &credentialsView{Name: c.Name, Users: c.UserNames()})
+	}
+	buf, err := json.Marshal(data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot serialize data to json format."))
+		return
+	}
+
+	w.Write(buf)
+}
+
+// SetUser adds a user to credential set, or changes password of existing user
+func (h *Handler) SetUser(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	_, err := h.Persistor.SetUser(r.PostFormValue("name"), r.PostFormValue("user"), r.PostFormValue("password"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	h.Credentials(w, r)
+}
+
+// DeleteUser removes a user from credential set
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	switch _, err := h.Persistor.DeleteUser(r.PostFormValue("name"), r.PostFormValue("user")); err {
+	case nil:
+	case ErrNoSuchCredentials, ErrNoSuchUser:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	h.Credentials(w, r)
+}
+
+// DeleteCredentials deletes a credential set not used by any server or mapping
+func (h *Handler) DeleteCredentials(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	switch err := h.Persistor.DeleteCredentials(r.PostFormValue("name")); err {
+	case nil:
+	case ErrNoSuchCredentials:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	case ErrCredentialsInUse:
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	h.Credentials(w, r)
+}
+
+// Auth sets basic auth of a server
+func (h *Handler) Auth(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	name := r.PostFormValue("name")
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("you must pass at least name"))
+		return
+	}
+
+	res, err := h.Persistor.SetAuth(name, r.PostFormValue("credentials"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if res == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("No such server"))
+		return
+	}
+
+	data := map[string]*serverSettings{
+		res.ServerName: settingsOf(res),
+	}
+	buf, err := json.Marshal(data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot serialize data to json format."))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	w.Write(buf)
+}
+
+// Access sets client address restriction of a server
+func (h *Handler) Access(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	name := r.PostFormValue("name")
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("you must pass at least name"))
+		return
+	}
+
+	var access *Access
+	if err := jsonFormValue(r, "access", &access); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if access != nil {
+		if err := access.Validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	res, err := h.Persistor.SetAccess(name, access)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if res == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("No such server"))
+		return
+	}
+
+	data := map[string]*serverSettings{
+		res.ServerName: settingsOf(res),
+	}
+	buf, err := json.Marshal(data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot serialize data to json format."))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	w.Write(buf)
+}
+
+// AddressLists lists all address lists
+func (h *Handler) AddressLists(w http.ResponseWriter, r *http.Request) {
+	buf, err := json.Marshal(h.Persistor.AddressLists())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot serialize data to json format."))
+		return
+	}
+
+	w.Write(buf)
+}
+
+// SaveAddressList creates or replaces an address list
+func (h *Handler) SaveAddressList(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	l := &AddressList{Name: r.PostFormValue("name")}
+	if err := json.Unmarshal([]byte(r.PostFormValue("cidrs")), &l.CIDRs); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("cidrs must be a json array of strings"))
+		return
+	}
+	if err := l.Validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if err := h.Persistor.SaveAddressList(l); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	h.AddressLists(w, r)
+}
+
+// DeleteAddressList deletes an address list not used by any server or mapping
+func (h *Handler) DeleteAddressList(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	switch err := h.Persistor.DeleteAddressList(r.PostFormValue("name")); err {
+	case nil:
+	case ErrNoSuchList:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	case ErrListInUse:
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	h.AddressLists(w, r)
+}
+
+// PurgeCache removes cached responses of a mapping
+func (h *Handler) PurgeCache(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	switch err := h.Persistor.PurgeCache(r.PostFormValue("name"), r.PostFormValue("path")); err {
+	case nil:
+	case ErrNoSuchMapping:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	case ErrNoCache:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Tuning sets timeouts, buffering and body size of a server
+func (h *Handler) Tuning(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	name := r.PostFormValue("name")
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("you must pass at least name"))
+		return
+	}
+
+	var tuning *Tuning
+	if err := jsonFormValue(r, "tuning", &tuning); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if tuning != nil {
+		if err := tuning.Validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	res, err := h.Persistor.SetTuning(name, tuning)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if res == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("No such server"))
+		return
+	}
+
+	data := map[string]*serverSettings{
+		res.ServerName: settingsOf(res),
+	}
+	buf, err := json.Marshal(data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot serialize data to json format."))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	w.Write(buf)
+}
+
+// Listen sets server names and listen addresses of a server
+func (h *Handler) Listen(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	name := r.PostFormValue("name")
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("you must pass at least name"))
+		return
+	}
+
+	var names []string
+	if err := jsonFormValue(r, "names", &names); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	var listens []*Listen
+	if err := jsonFormValue(r, "listens", &listens); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	res, err := h.Persistor.SetListen(name, names, listens)
+	switch err {
+	case nil:
+	case ErrPortInUse, ErrDefaultServer, ErrSharedH2C:
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if res == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("No such server"))
+		return
+	}
+
+	data := map[string]*serverSettings{
+		res.ServerName: settingsOf(res),
+	}
+	buf, err := json.Marshal(data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot serialize data to json format."))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	res.RLock()
+	conf := res.TLS
+	res.RUnlock()
+	if conf != nil && conf.Source != TLSSourceFile {
+		// certificate follows server names
+		go h.renewCertificates()
+	}
+	w.Write(buf)
+}
+
+// Streams lists all stream mappings
+func (h *Handler) Streams(w http.ResponseWriter, r *http.Request) {
+	buf, err := json.Marshal(h.Persistor.Streams())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot serialize data to json format."))
+		return
+	}
+
+	w.Write(buf)
+}
+
+// SaveStream creates or replaces a stream mapping
+func (h *Handler) SaveStream(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	s := &Stream{
+		Name:           r.PostFormValue("name"),
+		Protocol:       r.PostFormValue("protocol"),
+		Address:        r.PostFormValue("address"),
+		Upstream:       r.PostFormValue("upstream"),
+		ConnectTimeout: r.PostFormValue("connect_timeout"),
+		Timeout:        r.PostFormValue("timeout"),
+		Description:    r.PostFormValue("description"),
+	}
+	s.Port, _ = strconv.Atoi(r.PostFormValue("port"))
+	if err := s.Validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	switch err := h.Persistor.SaveStream(s); err {
+	case nil:
+	case ErrPortInUse:
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	h.Streams(w, r)
+}
+
+// DeleteStream deletes a stream mapping
+func (h *Handler) DeleteStream(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	switch err := h.Persistor.DeleteStream(r.PostFormValue("name")); err {
+	case nil:
+	case ErrNoSuchStream:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if !h.ReloadNginx() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot reload Nginx."))
+		return
+	}
+
+	h.Streams(w, r)
+}
+
+// overrideFromForm reads upstream override from posted form
+func overrideFromForm(r *http.Request) *Override {
+	return &Override{
+		Header:   r.PostFormValue("header"),
+		Cookie:   r.PostFormValue("cookie"),
+		Value:    r.PostFormValue("value"),
+		Upstream: r.PostFormValue("upstream"),
+		Owner:    r.PostFormValue("owner"),
+	}
+}
+
+// SetOverride adds or replaces an upstream override of a mapping, requests
+// with the header or cookie value are passed to the override upstream
+func (h *Handler) SetOverride(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	o := overrideFromForm(r)
+	if err := o.Validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	res, err := h.Persistor.SetOverride(r.PostFormValue("name"), r.PostFormValue("path"), o)
+	switch err {
+	case nil:
+	case ErrNoSuchMapping:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	h.writePaths(w, res)
+}
+
+// DeleteOverride removes an upstream override of a mapping
+func (h *Handler) DeleteOverride(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	res, err := h.Persistor.DeleteOverride(r.PostFormValue("name"), r.PostFormValue("path"), overrideFromForm(r))
+	switch err {
+	case nil:
+	case ErrNoSuchMapping, ErrNoSuchOverride:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	h.writePaths(w, res)
+}
+
+// writePaths reloads nginx and writes mappings of srv as response
+func (h *Handler) writePaths(w http.ResponseWriter, srv *NginxServer) {
+	data := map[string]map[string]*Mapping{
+		srv.ServerName: maskedPaths(srv),
 	}
 	buf, err := json.Marshal(data)
 	if err != nil {
@@ -0,0 +1,175 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// AuthOff disables basic auth of server in a mapping
+const AuthOff = "off"
+
+// credentialsName matches valid name of CredentialSet, it is used as file name
+var credentialsName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// apr1Prefix is scheme of Apache MD5 hash, nginx supports it on every platform
+// unlike bcrypt, which depends on crypt(3) of system
+const apr1Prefix = "$apr1$"
+
+// cryptAlphabet is base64 alphabet of crypt(3)
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// CredentialSet is a named group of users, written to a htpasswd file for
+// basic auth
+type CredentialSet struct {
+	Name string `json:"name"`
+	// user name to hashed password
+	Users map[string]string `json:"users"`
+
+	// path of htpasswd file, set by Persistor
+	file string
+}
+
+// NewCredentialSet creates an empty CredentialSet
+func NewCredentialSet(name string) (*CredentialSet, error) {
+	if !credentialsName.MatchString(name) || name == AuthOff {
+		return nil, fmt.Errorf("invalid credentials name %q", name)
+	}
+	return &CredentialSet{Name: name, Users: map[string]string{}}, nil
+}
+
+// SetUser adds user, or changes password if user exists
+func (c *CredentialSet) SetUser(user, password string) error {
+	if user == "" || strings.ContainsAny(user, ":\r\n") {
+		return fmt.Errorf("invalid user name %q", user)
+	}
+	if password == "" {
+		return errors.New("password cannot be empty")
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	c.Users[user] = hash
+	return nil
+}
+
+// UserNames lists users in c, sorted
+func (c *CredentialSet) UserNames() []string {
+	ret := make([]string, 0, len(c.Users))
+	for user := range c.Users {
+		ret = append(ret, user)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// htpasswd renders content of htpasswd file
+func (c *CredentialSet) htpasswd() []byte {
+	buf := &bytes.Buffer{}
+	for _, user := range c.UserNames() {
+		fmt.Fprintf(buf, "%s:%s\n", user, c.Users[user])
+	}
+	return buf.Bytes()
+}
+
+// SetAuth protects s with credentials, or removes protection if empty
+func (s *NginxServer) SetAuth(credentials string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Auth = credentials
+}
+
+// hashPassword hashes password in $apr1$ scheme with random salt
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	for i, b := range salt {
+		salt[i] = cryptAlphabet[b&0x3f]
+	}
+
+	return apr1(password, string(salt)), nil
+}
+
+// apr1 hashes password with salt like "htpasswd -m"
+func apr1(password, salt string) string {
+	pw := []byte(password)
+
+	h := md5.New()
+	h.Write(pw)
+	h.Write([]byte(salt))
+	h.Write(pw)
+	alt := h.Sum(nil)
+
+	h = md5.New()
+	h.Write(pw)
+	h.Write([]byte(apr1Prefix + salt))
+	for i := len(pw); i > 0; i -= md5.Size {
+		if i > md5.Size {
+			h.Write(alt)
+		} else {
+			h.Write(alt[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h = md5.New()
+		if i&1 == 1 {
+			h.Write(pw)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+		if i&1 == 1 {
+			h.Write(sum)
+		} else {
+			h.Write(pw)
+		}
+		sum = h.Sum(nil)
+	}
+
+	buf := bytes.NewBufferString(apr1Prefix + salt + "$")
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			buf.WriteByte(cryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(sum[g[0]])<<16|uint(sum[g[1]])<<8|uint(sum[g[2]]), 4)
+	}
+	encode(uint(sum[11]), 2)
+	return buf.String()
+}
+
+// byCredentialsName sorts credential sets by name
+type byCredentialsName []*CredentialSet
+
+func (s byCredentialsName) Len() int           { return len(s) }
+func (s byCredentialsName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s byCredentialsName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
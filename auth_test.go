@@ -0,0 +1,172 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// checkPassword reports whether password matches hash generated by hashPassword
+func checkPassword(hash, password string) bool {
+	if !strings.HasPrefix(hash, apr1Prefix) {
+		return false
+	}
+	arr := strings.SplitN(hash[len(apr1Prefix):], "$", 2)
+	return len(arr) == 2 && apr1(password, arr[0]) == hash
+}
+
+func TestAPR1(t *testing.T) {
+	// generated by "openssl passwd -apr1 -salt rOioh4Wh secret"
+	if actual := apr1("secret", "rOioh4Wh"); actual != "$apr1$rOioh4Wh$m5Xihq8Geii9fCC0S7.KC1" {
+		t.Errorf("Unexpected apr1 hash: %s", actual)
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := hashPassword("secret")
+	if err != nil {
+		t.Fatalf("Cannot hash password: %s", err)
+	}
+	if !strings.HasPrefix(hash, "$apr1$") {
+		t.Errorf("Unexpected hash scheme: %s", hash)
+	}
+	if !checkPassword(hash, "secret") {
+		t.Error("Password does not match its hash")
+	}
+	if checkPassword(hash, "Secret") {
+		t.Error("Wrong password matches the hash")
+	}
+
+	another, _ := hashPassword("secret")
+	if another == hash {
+		t.Error("Hashes of same password are not salted")
+	}
+}
+
+func TestCredentialSet(t *testing.T) {
+	for _, name := range []string{"", "off", "a b", "../etc", "a.b"} {
+		if _, err := NewCredentialSet(name); err == nil {
+			t.Errorf("Invalid credentials name %q is accepted", name)
+		}
+	}
+
+	c, err := NewCredentialSet("demo")
+	if err != nil {
+		t.Fatalf("Cannot create credential set: %s", err)
+	}
+	for user, password := range map[string]string{"": "a", "a:b": "a", "a\nb": "a", "bob": ""} {
+		if c.SetUser(user, password) == nil {
+			t.Errorf("Invalid user %q with password %q is accepted", user, password)
+		}
+	}
+
+	c.SetUser("bob", "b")
+	c.SetUser("alice", "a")
+	lines := strings.Split(strings.TrimSpace(string(c.htpasswd())), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "alice:$apr1$") || !strings.HasPrefix(lines[1], "bob:$apr1$") {
+		t.Errorf("Unexpected htpasswd content: %q", lines)
+	}
+}
+
+func TestAuthPersistor(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	p.CreateMapping("test.server", "/", &Mapping{Upstream: "http://a"})
+	p.CreateMapping("test.server", "/public/", &Mapping{Upstream: "http://a", Auth: AuthOff})
+
+	if _, err := p.SetAuth("test.server", "demo"); err != ErrNoSuchCredentials {
+		t.Errorf("Unknown credentials are accepted, got %v", err)
+	}
+	if _, err := p.SetUser("demo", "alice", "secret"); err != nil {
+		t.Fatalf("Cannot add user: %s", err)
+	}
+	if _, err := p.SetAuth("test.server", "demo"); err != nil {
+		t.Fatalf("Cannot set auth of server: %s", err)
+	}
+
+	file := p.credentialsFile("demo")
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Cannot read htpasswd file: %s", err)
+	}
+	arr := strings.SplitN(strings.TrimSpace(string(data)), ":", 2)
+	if len(arr) != 2 || arr[0] != "alice" || !checkPassword(arr[1], "secret") {
+		t.Errorf("Unexpected htpasswd content: %s", data)
+	}
+
+	conf, _ := ioutil.ReadFile(p.conffile)
+	for _, expect := range []string{
+		"    auth_basic \"demo\";\n    auth_basic_user_file " + file + ";\n",
+		"    location /public/ {\n        auth_basic off;\n",
+	} {
+		if !bytes.Contains(conf, []byte(expect)) {
+			t.Errorf("Config does not contain %q:\n%s", expect, conf)
+		}
+	}
+
+	if err := p.DeleteCredentials("demo"); err != ErrCredentialsInUse {
+		t.Errorf("Credentials in use are deleted, got %v", err)
+	}
+	if _, err := p.DeleteUser("demo", "bob"); err != ErrNoSuchUser {
+		t.Errorf("Unknown user is deleted, got %v", err)
+	}
+	p.SetAuth("test.server", "")
+	if err := p.DeleteCredentials("demo"); err != nil {
+		t.Errorf("Cannot delete credentials: %s", err)
+	}
+	if _, err := ioutil.ReadFile(file); err == nil {
+		t.Error("htpasswd file is not removed")
+	}
+}
+
+func TestAuthUnknownCredentials(t *testing.T) {
+	s := NewServer("example.com")
+	s.CreateMapping("/", &Mapping{Upstream: "http://a", Auth: "demo"})
+
+	if actual := s.Export(); !strings.Contains(actual, "    location / {\n        deny all;\n") {
+		t.Errorf("Mapping with unknown credentials is not denied:\n%s", actual)
+	}
+}
+
+func TestAuthAPI(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+	h := &Handler{Persistor: p, ReloadNginx: func() bool { return true }}
+
+	post := func(f http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		f(w, r)
+		return w
+	}
+
+	form := url.Values{"name": {"test.server"}, "path": {"/"}, "upstream": {"http://a"}, "auth": {"demo"}}
+	if w := post(h.Create, form); w.Code != http.StatusBadRequest {
+		t.Errorf("Unknown credentials are accepted, got %d", w.Code)
+	}
+
+	p.SetUser("demo", "alice", "secret")
+	if w := post(h.Create, form); w.Code != http.StatusOK {
+		t.Fatalf("Cannot create mapping with auth: %d %s", w.Code, w.Body)
+	}
+	if m := p.List()["test.server"].List()["/"]; m.Auth != "demo" {
+		t.Errorf("Auth of created mapping is %q", m.Auth)
+	}
+
+	form = url.Values{"name": {"test.server"}, "path": {"/"}, "new_path": {"/"}, "new_upstream": {"http://a"}, "new_auth": {AuthOff}}
+	if w := post(h.Modify, form); w.Code != http.StatusOK {
+		t.Fatalf("Cannot modify auth of mapping: %d %s", w.Code, w.Body)
+	}
+	if m := p.List()["test.server"].List()["/"]; m.Auth != AuthOff {
+		t.Errorf("Auth of modified mapping is %q", m.Auth)
+	}
+}
@@ -0,0 +1,236 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 90 * 24 * time.Hour
+
+	// leaf certificates are renewed if expiring within renewBefore
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// LocalCA is a certificate authority issuing certificates for development
+// hostnames. It is created once and kept in a directory, together with all
+// certificates it issues.
+type LocalCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  crypto.Signer
+	sync.Mutex
+}
+
+// OpenLocalCA loads CA from dir, a new one is created if not exist
+func OpenLocalCA(dir string) (*LocalCA, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	ca := &LocalCA{dir: dir}
+	if _, err := os.Stat(ca.CertFile()); os.IsNotExist(err) {
+		if err = ca.create(); err != nil {
+			return nil, err
+		}
+	}
+
+	cert, key, err := loadKeyPair(ca.CertFile(), filepath.Join(dir, "ca.key"))
+	if err != nil {
+		return nil, err
+	}
+	ca.cert = cert
+	ca.key = key
+	return ca, nil
+}
+
+// CertFile returns path to the CA certificate
+func (ca *LocalCA) CertFile() string {
+	return filepath.Join(ca.dir, "ca.crt")
+}
+
+// Paths returns path to certificate and key of host
+func (ca *LocalCA) Paths(host string) (cert, key string) {
+	base := filepath.Join(ca.dir, "local-"+certName(host))
+	return base + ".crt", base + ".key"
+}
+
+// safeCertName matches host names safe to be used as file name as is
+var safeCertName = regexp.MustCompile(`^[A-Za-z0-9-][A-Za-z0-9.-]*$`)
+
+// certName returns base name of certificate files of host. Names like "../a"
+// could escape the certs directory, so unsafe ones are hashed.
+func certName(host string) string {
+	if safeCertName.MatchString(host) {
+		return host
+	}
+	sum := sha256.Sum256([]byte(host))
+	return "sha256-" + hex.EncodeToString(sum[:])
+}
+
+func (ca *LocalCA) create() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Yeast Local CA", Organization: []string{"Yeast"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	return writeKeyPair(ca.CertFile(), filepath.Join(ca.dir, "ca.key"), [][]byte{der}, key)
+}
+
+// Valid reports whether certificate of hosts exists, covers all of them and
+// is not expiring
+func (ca *LocalCA) Valid(hosts ...string) bool {
+	if len(hosts) == 0 {
+		return false
+	}
+
+	ca.Lock()
+	defer ca.Unlock()
+
+	leaf, _, err := loadKeyPair(ca.Paths(hosts[0]))
+	return err == nil && !needRenew(leaf) && covers(leaf, hosts)
+}
+
+// Issue returns certificate and key of hosts, which is issued or renewed if
+// needed. First host is used as common name.
+func (ca *LocalCA) Issue(hosts ...string) (cert, key string, err error) {
+	if len(hosts) == 0 || hosts[0] == "" {
+		return "", "", errors.New("cannot issue certificate without server name")
+	}
+	host := hosts[0]
+
+	ca.Lock()
+	defer ca.Unlock()
+
+	cert, key = ca.Paths(host)
+	if leaf, _, err := loadKeyPair(cert, key); err == nil && !needRenew(leaf) && covers(leaf, hosts) {
+		return cert, key, nil
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &priv.PublicKey, ca.key)
+	if err != nil {
+		return
+	}
+
+	err = writeKeyPair(cert, key, [][]byte{der}, priv)
+	return
+}
+
+// needRenew reports whether cert expires soon
+func needRenew(cert *x509.Certificate) bool {
+	return time.Now().Add(renewBefore).After(cert.NotAfter)
+}
+
+// covers reports whether cert is valid for all hosts
+func covers(cert *x509.Certificate, hosts []string) bool {
+	for _, h := range hosts {
+		if cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// writeKeyPair writes certificate chain and its key, leaf certificate first
+func writeKeyPair(certFile, keyFile string, chain [][]byte, key *ecdsa.PrivateKey) error {
+	if err := writeKey(keyFile, key); err != nil {
+		return err
+	}
+
+	var buf []byte
+	for _, der := range chain {
+		buf = append(buf, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return ioutil.WriteFile(certFile, buf, 0644)
+}
+
+// loadKeyPair loads leaf certificate and its key
+func loadKeyPair(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("no certificate found in " + certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := loadKey(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, key, nil
+}
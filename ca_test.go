@@ -0,0 +1,174 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempCA(t *testing.T) *LocalCA {
+	dir, err := ioutil.TempDir("", "ca")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+
+	ca, err := OpenLocalCA(dir)
+	if err != nil {
+		t.Fatalf("Cannot create local CA: %s", err)
+	}
+	return ca
+}
+
+func TestCAIssue(t *testing.T) {
+	ca := tempCA(t)
+	defer os.RemoveAll(ca.dir)
+
+	cert, key, err := ca.Issue("orders.dev.local")
+	if err != nil {
+		t.Fatalf("Cannot issue certificate: %s", err)
+	}
+
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		t.Fatalf("Issued key pair is invalid: %s", err)
+	}
+	leaf, _ := x509.ParseCertificate(pair.Certificate[0])
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: "orders.dev.local", Roots: pool})
+	if err != nil {
+		t.Errorf("Issued certificate is not trusted by CA: %s", err)
+	}
+}
+
+func TestCAReopen(t *testing.T) {
+	ca := tempCA(t)
+	defer os.RemoveAll(ca.dir)
+
+	again, err := OpenLocalCA(ca.dir)
+	if err != nil {
+		t.Fatalf("Cannot reopen local CA: %s", err)
+	}
+	if !again.cert.Equal(ca.cert) {
+		t.Error("CA is recreated when reopening")
+	}
+}
+
+func TestCARenew(t *testing.T) {
+	ca := tempCA(t)
+	defer os.RemoveAll(ca.dir)
+
+	if ca.Valid("a.dev.local") {
+		t.Error("Certificate not issued yet should not be valid")
+	}
+	cert, _, _ := ca.Issue("a.dev.local")
+	if !ca.Valid("a.dev.local") {
+		t.Error("Just issued certificate should be valid")
+	}
+
+	old, _ := ioutil.ReadFile(cert)
+	ca.Issue("a.dev.local")
+	if actual, _ := ioutil.ReadFile(cert); string(actual) != string(old) {
+		t.Error("Valid certificate should not be reissued")
+	}
+
+	leaf, _, _ := loadKeyPair(ca.Paths("a.dev.local"))
+	if needRenew(leaf) {
+		t.Error("Just issued certificate should not need renewing")
+	}
+	leaf.NotAfter = time.Now().Add(renewBefore - time.Hour)
+	if !needRenew(leaf) {
+		t.Error("Expiring certificate should be renewed")
+	}
+}
+
+func TestCAAliases(t *testing.T) {
+	ca := tempCA(t)
+	defer os.RemoveAll(ca.dir)
+
+	ca.Issue("a.dev.local")
+	if ca.Valid("a.dev.local", "b.dev.local") {
+		t.Error("Certificate not covering alias should not be valid")
+	}
+	cert, key, err := ca.Issue("a.dev.local", "b.dev.local", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Cannot issue certificate: %s", err)
+	}
+	leaf, _, _ := loadKeyPair(cert, key)
+	for _, h := range []string{"a.dev.local", "b.dev.local", "127.0.0.1"} {
+		if err := leaf.VerifyHostname(h); err != nil {
+			t.Errorf("Certificate does not cover %s: %s", h, err)
+		}
+	}
+	if !ca.Valid("a.dev.local", "b.dev.local", "127.0.0.1") {
+		t.Error("Certificate covering all names should be valid")
+	}
+}
+
+func TestCAPersistor(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+	ca := tempCA(t)
+	defer os.RemoveAll(ca.dir)
+
+	p.Create("orders.dev.local", "/", "http://upstream", "")
+	if _, err := p.SetTLS("orders.dev.local", &TLSConfig{Source: TLSSourceLocal}); err == nil {
+		t.Error("Local certificate should be rejected without CA")
+	}
+
+	p.SetIssuer(TLSSourceLocal, ca)
+	srv, err := p.SetTLS("orders.dev.local", &TLSConfig{Source: TLSSourceLocal})
+	if err != nil {
+		t.Fatalf("Cannot use local certificate: %s", err)
+	}
+	cert, key := ca.Paths("orders.dev.local")
+	if srv.TLS.Certificate != cert || srv.TLS.Key != key {
+		t.Errorf("Certificate paths are not managed by local CA: %#v", srv.TLS)
+	}
+	if _, err := os.Stat(cert); err != nil {
+		t.Errorf("Certificate is not issued when enabling https: %s", err)
+	}
+	if actual := srv.Export(); !strings.Contains(actual, "ssl_certificate "+cert+";") {
+		t.Errorf("Server should be rendered with issued certificate, got %s", actual)
+	}
+	if renewed, _ := p.RenewCertificates(); renewed {
+		t.Error("Just issued certificate should not be reissued")
+	}
+
+	if _, err := p.SetListen("orders.dev.local", []string{"orders.dev.local", "api.dev.local"}, nil); err != nil {
+		t.Fatalf("Cannot set server names: %s", err)
+	}
+	if !ca.Valid("orders.dev.local", "api.dev.local") {
+		t.Error("Certificate is not reissued for new server names")
+	}
+
+	os.Remove(cert)
+	if renewed, err := p.RenewCertificates(); !renewed || err != nil {
+		t.Errorf("Missing certificate is not reissued: %s", err)
+	}
+	if renewed, _ := p.RenewCertificates(); renewed {
+		t.Error("Valid certificate should not be reissued")
+	}
+}
+
+func TestCAPaths(t *testing.T) {
+	ca := &LocalCA{dir: "/certs"}
+	for _, host := range []string{"../../etc/passwd", "a/b", "..", "::1"} {
+		cert, key := ca.Paths(host)
+		if filepath.Dir(cert) != "/certs" || filepath.Dir(key) != "/certs" {
+			t.Errorf("Paths of %s escape certs dir: %s %s", host, cert, key)
+		}
+	}
+	if cert, _ := ca.Paths("orders.dev.local"); cert != "/certs/local-orders.dev.local.crt" {
+		t.Errorf("Paths of safe host name should be kept, got %s", cert)
+	}
+}
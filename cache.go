@@ -0,0 +1,99 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// cacheStatus matches status code used in Cache.Valid
+var cacheStatus = regexp.MustCompile(`^([1-5][0-9][0-9]|any)$`)
+
+// defaultCacheDir is where cached files are put if not specified
+const defaultCacheDir = "/var/cache/nginx/yeast"
+
+// cacheZoneSize is size of shared memory zone storing keys of each Cache
+const cacheZoneSize = "10m"
+
+// Cache describes how responses from upstream are cached
+type Cache struct {
+	Enabled bool `json:"enabled"`
+	// status code, or "any", to time responses are cached
+	Valid map[string]string `json:"valid,omitempty"`
+	// proxy_cache_key, nginx default if empty
+	Key string `json:"key,omitempty"`
+	// requests with this header are passed to upstream without cache
+	Bypass string `json:"bypass,omitempty"`
+}
+
+// Validate checks if c is malformed
+func (c *Cache) Validate() error {
+	for status, t := range c.Valid {
+		if !cacheStatus.MatchString(status) {
+			return fmt.Errorf("invalid cache status %s", status)
+		}
+		if !isTime(t) {
+			return fmt.Errorf("invalid cache time %s", t)
+		}
+	}
+	if strings.ContainsAny(c.Key, "\"\r\n") {
+		return fmt.Errorf("cache key contains invalid characters")
+	}
+	if c.Bypass != "" && !headerName.MatchString(c.Bypass) {
+		return fmt.Errorf("invalid header name %q", c.Bypass)
+	}
+	return nil
+}
+
+// cacheValid is a proxy_cache_valid directive
+type cacheValid struct {
+	Status string
+	Time   string
+}
+
+// cacheRule is rendered form of Cache
+type cacheRule struct {
+	Zone string
+	Size string
+	// directory of cached files
+	Path string
+
+	Valid  []*cacheValid
+	Key    string
+	Bypass string
+}
+
+// cacheZone is name of cache zone of location id
+func cacheZone(id string) string {
+	return "cache_" + id
+}
+
+// rule renders c, id identifies the location and dir is where cached files
+// are put
+func (c *Cache) rule(id, dir string) *cacheRule {
+	ret := &cacheRule{
+		Zone: cacheZone(id),
+		Size: cacheZoneSize,
+		Path: filepath.Join(dir, cacheZone(id)),
+		Key:  c.Key,
+	}
+	if c.Bypass != "" {
+		ret.Bypass = headerVariable(c.Bypass)
+	}
+
+	statuses := make([]string, 0, len(c.Valid))
+	for status := range c.Valid {
+		statuses = append(statuses, status)
+	}
+	// "any" goes last
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		ret.Valid = append(ret.Valid, &cacheValid{status, c.Valid[status]})
+	}
+	return ret
+}
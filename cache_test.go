@@ -0,0 +1,91 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCacheValidate(t *testing.T) {
+	valid := []*Mapping{
+		{Upstream: "http://a", Cache: &Cache{Enabled: true}},
+		{Upstream: "http://a", Cache: &Cache{
+			Enabled: true,
+			Valid:   map[string]string{"200": "10m", "404": "1m", "any": "30s"},
+			Key:     "$scheme$host$request_uri",
+			Bypass:  "X-No-Cache",
+		}},
+	}
+	for _, m := range valid {
+		if err := m.Validate("/"); err != nil {
+			t.Errorf("Valid cache %#v is rejected: %s", m.Cache, err)
+		}
+	}
+
+	invalid := []*Mapping{
+		{Upstream: "http://a", Cache: &Cache{Valid: map[string]string{"2xx": "10m"}}},
+		{Upstream: "http://a", Cache: &Cache{Valid: map[string]string{"200": "10 minutes"}}},
+		{Upstream: "http://a", Cache: &Cache{Key: `"$host`}},
+		{Upstream: "http://a", Cache: &Cache{Bypass: "X No Cache"}},
+		{Type: MappingResponse, Response: &Response{Status: 200}, Cache: &Cache{Enabled: true}},
+	}
+	for _, m := range invalid {
+		if m.Validate("/") == nil {
+			t.Errorf("Invalid cache %#v is accepted", m.Cache)
+		}
+	}
+}
+
+func TestCachePersistor(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	p.CreateMapping("test.server", "/api/", &Mapping{Upstream: "http://api", Cache: &Cache{
+		Enabled: true,
+		Valid:   map[string]string{"any": "1m", "200": "10m"},
+		Bypass:  "X-No-Cache",
+	}})
+	p.CreateMapping("test.server", "/off/", &Mapping{Upstream: "http://off", Cache: &Cache{}})
+	p.CreateMapping("test.server", "/", &Mapping{Upstream: "http://a"})
+
+	zone := cacheZone(locationID("test.server", "/api/"))
+	dir := filepath.Join(p.cacheDir(), zone)
+	conf, _ := ioutil.ReadFile(p.conffile)
+	for _, expect := range []string{
+		"proxy_cache_path " + dir + " levels=1:2 keys_zone=" + zone + ":10m use_temp_path=off;\n\n",
+		"        proxy_cache " + zone + ";\n" +
+			"        proxy_cache_valid 200 10m;\n" +
+			"        proxy_cache_valid any 1m;\n" +
+			"        proxy_cache_bypass $http_x_no_cache;\n" +
+			"        proxy_no_cache $http_x_no_cache;\n",
+	} {
+		if !strings.Contains(string(conf), expect) {
+			t.Errorf("Config does not contain %q:\n%s", expect, conf)
+		}
+	}
+	if strings.Count(string(conf), "proxy_cache ") != 1 {
+		t.Errorf("Disabled cache is rendered:\n%s", conf)
+	}
+
+	os.MkdirAll(filepath.Join(dir, "a", "bc"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "a", "bc", "0123abc"), []byte("cached"), 0644)
+	if err := p.PurgeCache("test.server", "/api/"); err != nil {
+		t.Fatalf("Cannot purge cache: %s", err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 0 {
+		t.Errorf("Cache directory is not emptied, got %v, %v", files, err)
+	}
+
+	if err := p.PurgeCache("test.server", "/"); err != ErrNoCache {
+		t.Errorf("Purging mapping without cache returns %v", err)
+	}
+	if err := p.PurgeCache("test.server", "/none/"); err != ErrNoSuchMapping {
+		t.Errorf("Purging unknown mapping returns %v", err)
+	}
+}
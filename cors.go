@@ -0,0 +1,123 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// corsMethod matches valid http method
+var corsMethod = regexp.MustCompile(`^[A-Z]+$`)
+
+// default value of Access-Control-Allow-Methods
+const corsMethods = "GET, HEAD, POST, PUT, PATCH, DELETE"
+
+// CORS describes cross-origin resource sharing policy of a mapping
+type CORS struct {
+	// allowed origins like "http://localhost:3000", or "*" for any origin
+	Origins []string `json:"origins,omitempty"`
+	// allow any origin by reflecting Origin header, instead of Origins
+	Reflect bool `json:"reflect,omitempty"`
+	// allowed methods, common methods if empty
+	Methods []string `json:"methods,omitempty"`
+	// allowed request headers, headers requested by preflight if empty
+	Headers     []string `json:"headers,omitempty"`
+	Credentials bool     `json:"credentials,omitempty"`
+	// seconds to cache preflight response, browser default if 0
+	MaxAge int `json:"max_age,omitempty"`
+}
+
+// Validate checks if c is malformed
+func (c *CORS) Validate() error {
+	if c.Reflect == (len(c.Origins) > 0) {
+		return fmt.Errorf("cors needs either origins or reflect")
+	}
+
+	for _, o := range c.Origins {
+		if o == "*" {
+			if len(c.Origins) > 1 {
+				return fmt.Errorf("* cannot be used with other origins")
+			}
+			if c.Credentials {
+				return fmt.Errorf("credentials cannot be used with *, use reflect instead")
+			}
+			continue
+		}
+
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+			return fmt.Errorf("invalid origin %s, should be like https://example.com", o)
+		}
+		if strings.ContainsAny(o, "\"'\\") {
+			return fmt.Errorf("invalid origin %s", o)
+		}
+	}
+
+	for _, m := range c.Methods {
+		if !corsMethod.MatchString(m) {
+			return fmt.Errorf("invalid method %q", m)
+		}
+	}
+	for _, h := range c.Headers {
+		if !headerName.MatchString(h) {
+			return fmt.Errorf("invalid header name %q", h)
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("max age cannot be negative")
+	}
+
+	return nil
+}
+
+// corsRule is rendered form of CORS
+type corsRule struct {
+	// variable defined by "cors" template, empty if not needed
+	Variable string
+	// origins mapped to Variable
+	Origins []string
+
+	// values of Access-Control-* headers
+	Origin      string
+	Methods     string
+	Headers     string
+	Credentials bool
+	MaxAge      int
+	// response varies by Origin header
+	Vary bool
+}
+
+// rule renders c, id identifies the location
+func (c *CORS) rule(id string) *corsRule {
+	ret := &corsRule{
+		Methods:     corsMethods,
+		Headers:     "$http_access_control_request_headers",
+		Credentials: c.Credentials,
+		MaxAge:      c.MaxAge,
+	}
+
+	switch {
+	case c.Reflect:
+		ret.Origin = "$http_origin"
+		ret.Vary = true
+	case c.Origins[0] == "*":
+		ret.Origin = "*"
+	default:
+		ret.Variable = "$cors_" + id
+		ret.Origins = c.Origins
+		ret.Origin = ret.Variable
+		ret.Vary = true
+	}
+
+	if len(c.Methods) > 0 {
+		ret.Methods = strings.Join(c.Methods, ", ")
+	}
+	if len(c.Headers) > 0 {
+		ret.Headers = strings.Join(c.Headers, ", ")
+	}
+	return ret
+}
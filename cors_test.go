@@ -0,0 +1,124 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCORSValidate(t *testing.T) {
+	valid := []*CORS{
+		{Origins: []string{"*"}},
+		{Origins: []string{"http://localhost:3000", "https://app.example.com"}, Credentials: true},
+		{Reflect: true, Methods: []string{"GET", "POST"}, Headers: []string{"Authorization", "Content-Type"}, MaxAge: 600},
+	}
+	for _, c := range valid {
+		if err := c.Validate(); err != nil {
+			t.Errorf("Valid cors %#v is rejected: %s", c, err)
+		}
+	}
+
+	invalid := []*CORS{
+		{},
+		{Reflect: true, Origins: []string{"http://localhost:3000"}},
+		{Origins: []string{"*"}, Credentials: true},
+		{Origins: []string{"*", "http://localhost:3000"}},
+		{Origins: []string{"localhost:3000"}},
+		{Origins: []string{"http://localhost:3000/app"}},
+		{Origins: []string{"ftp://example.com"}},
+		{Reflect: true, Methods: []string{"get"}},
+		{Reflect: true, Headers: []string{"X Bad"}},
+		{Reflect: true, MaxAge: -1},
+	}
+	for _, c := range invalid {
+		if c.Validate() == nil {
+			t.Errorf("Invalid cors %#v is accepted", c)
+		}
+	}
+}
+
+func TestCORSExport(t *testing.T) {
+	expect := `server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 80;
+
+    location /api/ {
+        if ($request_method = OPTIONS) {
+            add_header Access-Control-Allow-Origin $http_origin always;
+            add_header Access-Control-Allow-Methods "GET, POST" always;
+            add_header Access-Control-Allow-Headers "$http_access_control_request_headers" always;
+            add_header Access-Control-Allow-Credentials true always;
+            add_header Access-Control-Max-Age 600 always;
+            add_header Vary Origin always;
+            return 204;
+        }
+        add_header Access-Control-Allow-Origin $http_origin always;
+        add_header Access-Control-Allow-Credentials true always;
+        add_header Vary Origin always;
+        proxy_pass http://api;
+        include proxy_params;
+        proxy_hide_header Access-Control-Allow-Origin;
+        proxy_hide_header Access-Control-Allow-Credentials;
+        
+    }
+
+    location /public/ {
+        if ($request_method = OPTIONS) {
+            add_header Access-Control-Allow-Origin * always;
+            add_header Access-Control-Allow-Methods "GET, HEAD, POST, PUT, PATCH, DELETE" always;
+            add_header Access-Control-Allow-Headers "Content-Type" always;
+            return 204;
+        }
+        add_header Access-Control-Allow-Origin * always;
+        return 200 "ok";
+        
+    }
+
+}`
+
+	s := NewServer("example.com")
+	s.CreateMapping("/api/", &Mapping{
+		Upstream: "http://api",
+		CORS:     &CORS{Reflect: true, Methods: []string{"GET", "POST"}, Credentials: true, MaxAge: 600},
+	})
+	s.CreateMapping("/public/", &Mapping{
+		Type:     MappingResponse,
+		Response: &Response{Status: 200, Body: "ok"},
+		CORS:     &CORS{Origins: []string{"*"}, Headers: []string{"Content-Type"}},
+	})
+	if actual := s.Export(); actual != expect {
+		t.Errorf("CORS returns %s", actual)
+	}
+}
+
+func TestCORSOriginMap(t *testing.T) {
+	s := NewServer("example.com")
+	s.CreateMapping("/api/", &Mapping{
+		Upstream: "http://api",
+		CORS:     &CORS{Origins: []string{"http://localhost:3000", "https://app.example.com"}},
+	})
+	variable := "$cors_" + locationID("example.com", "/api/")
+
+	buf := &bytes.Buffer{}
+	if err := defaultRenderer.Config(buf, &Dataset{Servers: []*NginxServer{s}}); err != nil {
+		t.Fatalf("Cannot render config: %s", err)
+	}
+
+	expect := `map $http_origin ` + variable + ` {
+    default "";
+    "http://localhost:3000" $http_origin;
+    "https://app.example.com" $http_origin;
+}
+`
+	actual := buf.String()
+	if !strings.HasPrefix(actual, expect) {
+		t.Errorf("Origin map is not rendered, got:\n%s", actual)
+	}
+	if !strings.Contains(actual, "add_header Access-Control-Allow-Origin "+variable+" always;") {
+		t.Errorf("Origin map is not used, got:\n%s", actual)
+	}
+}
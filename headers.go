@@ -0,0 +1,130 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// headerName matches valid name of http header
+var headerName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Header is a http header
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+	// add header to every response, including errors, only for response headers
+	Always bool `json:"always,omitempty"`
+}
+
+// HeaderRules changes headers of requests sent to upstream and responses sent
+// to client
+type HeaderRules struct {
+	// request headers set by proxy_set_header, empty value removes the header
+	Set []*Header `json:"set,omitempty"`
+	// response headers added by add_header
+	Add []*Header `json:"add,omitempty"`
+	// upstream response headers removed by proxy_hide_header
+	Hide []string `json:"hide,omitempty"`
+}
+
+// Validate checks if h is malformed, proxied tells whether it is used in a
+// mapping passing requests to upstream
+func (h *HeaderRules) Validate(proxied bool) error {
+	if !proxied && (len(h.Set) > 0 || len(h.Hide) > 0) {
+		return fmt.Errorf("request headers and hidden headers need upstream")
+	}
+
+	seen := map[string]bool{}
+	for _, hdr := range h.Set {
+		if err := hdr.validate(); err != nil {
+			return err
+		}
+		if hdr.Always {
+			return fmt.Errorf("always cannot be used with request header %s", hdr.Name)
+		}
+		if seen[strings.ToLower(hdr.Name)] {
+			return fmt.Errorf("request header %s is set more than once", hdr.Name)
+		}
+		seen[strings.ToLower(hdr.Name)] = true
+	}
+
+	for _, hdr := range h.Add {
+		if err := hdr.validate(); err != nil {
+			return err
+		}
+		if hdr.Value == "" {
+			return fmt.Errorf("response header %s needs a value", hdr.Name)
+		}
+	}
+
+	seen = map[string]bool{}
+	for _, name := range h.Hide {
+		if !headerName.MatchString(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if seen[strings.ToLower(name)] {
+			return fmt.Errorf("header %s is hidden more than once", name)
+		}
+		seen[strings.ToLower(name)] = true
+	}
+
+	return nil
+}
+
+func (h *Header) validate() error {
+	if !headerName.MatchString(h.Name) {
+		return fmt.Errorf("invalid header name %q", h.Name)
+	}
+	if strings.ContainsAny(h.Value, "\r\n") {
+		return fmt.Errorf("value of header %s contains newline", h.Name)
+	}
+	return nil
+}
+
+// sorted returns copy of h with headers sorted by name, values of same name
+// keep their order
+func (h *HeaderRules) sorted() *HeaderRules {
+	ret := &HeaderRules{
+		Set:  make([]*Header, len(h.Set)),
+		Add:  make([]*Header, len(h.Add)),
+		Hide: make([]string, len(h.Hide)),
+	}
+	copy(ret.Set, h.Set)
+	copy(ret.Add, h.Add)
+	copy(ret.Hide, h.Hide)
+
+	sort.Stable(headersByName(ret.Set))
+	sort.Stable(headersByName(ret.Add))
+	sort.Strings(ret.Hide)
+	return ret
+}
+
+// headersByName sorts headers by name, case-insensitively
+type headersByName []*Header
+
+func (h headersByName) Len() int { return len(h) }
+func (h headersByName) Less(i, j int) bool {
+	return strings.ToLower(h[i].Name) < strings.ToLower(h[j].Name)
+}
+func (h headersByName) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+// headerVariable returns nginx variable of request header name
+func headerVariable(name string) string {
+	return "$http_" + strings.Replace(strings.ToLower(name), "-", "_", -1)
+}
+
+// quoteEscaper escapes string into double quoted nginx string
+var quoteEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+)
+
+// quote returns s as double quoted nginx string
+func quote(s string) string {
+	return `"` + quoteEscaper.Replace(s) + `"`
+}
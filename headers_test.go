@@ -0,0 +1,80 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import "testing"
+
+func TestHeadersValidate(t *testing.T) {
+	valid := []*Mapping{
+		{Upstream: "http://a", Headers: &HeaderRules{
+			Set:  []*Header{{Name: "Host", Value: "$host"}, {Name: "Accept-Encoding"}},
+			Add:  []*Header{{Name: "Set-Cookie", Value: "a=1"}, {Name: "Set-Cookie", Value: "b=2", Always: true}},
+			Hide: []string{"X-Powered-By", "Server"},
+		}},
+		{Type: MappingResponse, Response: &Response{Status: 204}, Headers: &HeaderRules{
+			Add: []*Header{{Name: "Cache-Control", Value: "no-store"}},
+		}},
+	}
+	for _, m := range valid {
+		if err := m.Validate("/"); err != nil {
+			t.Errorf("Valid headers %#v are rejected: %s", m.Headers, err)
+		}
+	}
+
+	invalid := []*HeaderRules{
+		{Set: []*Header{{Name: "X Bad", Value: "1"}}},
+		{Set: []*Header{{Name: "X-A", Value: "1\r\nX-B: 2"}}},
+		{Set: []*Header{{Name: "X-A", Value: "1"}, {Name: "x-a", Value: "2"}}},
+		{Set: []*Header{{Name: "X-A", Value: "1", Always: true}}},
+		{Add: []*Header{{Name: "X-A"}}},
+		{Add: []*Header{{Name: "X-A;", Value: "1"}}},
+		{Hide: []string{"Server", "server"}},
+		{Hide: []string{""}},
+	}
+	for _, h := range invalid {
+		m := &Mapping{Upstream: "http://a", Headers: h}
+		if m.Validate("/") == nil {
+			t.Errorf("Invalid headers %#v are accepted", h)
+		}
+	}
+
+	m := &Mapping{Type: MappingResponse, Response: &Response{Status: 204}, Headers: &HeaderRules{
+		Set: []*Header{{Name: "Host", Value: "a"}},
+	}}
+	if m.Validate("/") == nil {
+		t.Error("Request headers are accepted without upstream")
+	}
+}
+
+func TestHeadersExport(t *testing.T) {
+	expect := `server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 80;
+
+    location / {
+        proxy_pass http://a;
+        include proxy_params;
+        proxy_set_header Accept-Encoding "";
+        proxy_set_header X-Real-Host "$host";
+        proxy_hide_header Server;
+        proxy_hide_header X-Powered-By;
+        add_header Content-Security-Policy "default-src 'self'; img-src \"*\"";
+        add_header Set-Cookie "b=2" always;
+        add_header Set-Cookie "a=1";
+        
+    }
+
+}`
+
+	s := NewServer("example.com")
+	s.CreateMapping("/", &Mapping{Upstream: "http://a", Headers: &HeaderRules{
+		Set:  []*Header{{Name: "X-Real-Host", Value: "$host"}, {Name: "Accept-Encoding"}},
+		Add:  []*Header{{Name: "Set-Cookie", Value: "b=2", Always: true}, {Name: "Set-Cookie", Value: "a=1"}, {Name: "Content-Security-Policy", Value: `default-src 'self'; img-src "*"`}},
+		Hide: []string{"X-Powered-By", "Server"},
+	}})
+	if actual := s.Export(); actual != expect {
+		t.Errorf("Headers returns %s", actual)
+	}
+}
@@ -0,0 +1,206 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Listen is an address a server listens on
+type Listen struct {
+	// IPv4 or IPv6 address, all addresses if empty
+	Address string `json:"address,omitempty"`
+	// 80, or 443 if https is enabled, if 0
+	Port int `json:"port,omitempty"`
+	// listen on all IPv6 addresses, used when Address is empty
+	IPv6    bool `json:"ipv6,omitempty"`
+	Default bool `json:"default_server,omitempty"`
+}
+
+// Validate checks if l is malformed
+func (l *Listen) Validate() error {
+	if l.Port < 0 || l.Port > 65535 {
+		return fmt.Errorf("invalid port %d", l.Port)
+	}
+	if l.Address == "" {
+		return nil
+	}
+
+	ip := net.ParseIP(l.Address)
+	if ip == nil {
+		return fmt.Errorf("invalid listen address %s", l.Address)
+	}
+	if l.IPv6 && ip.To4() != nil {
+		return fmt.Errorf("%s is not an IPv6 address", l.Address)
+	}
+	return nil
+}
+
+// addr returns address argument of listen directive
+func (l *Listen) addr() string {
+	port := strconv.Itoa(l.Port)
+	switch {
+	case l.Address != "":
+		return net.JoinHostPort(l.Address, port)
+	case l.IPv6:
+		return "[::]:" + port
+	}
+	return port
+}
+
+// validateServerName checks if name can be used in server_name, including
+// wildcards like "*.example.com" and regular expressions like "~^www\d+\."
+func validateServerName(name string) error {
+	if strings.HasPrefix(name, "~") {
+		if err := checkRegex(name[1:]); err != nil {
+			return fmt.Errorf("invalid server name %s: %s", name, err)
+		}
+		return nil
+	}
+
+	if name == "" || strings.ContainsAny(name, " \t\n;{}\"'/:") {
+		return fmt.Errorf("invalid server name %q", name)
+	}
+	if strings.Count(name, "*") > 1 ||
+		(strings.Contains(name, "*") && !strings.HasPrefix(name, "*.") && !strings.HasSuffix(name, ".*")) {
+		return fmt.Errorf("wildcard should be at start or end of server name %s", name)
+	}
+	return nil
+}
+
+// serverNameArg returns name quoted if needed
+func serverNameArg(name string) string {
+	if strings.HasPrefix(name, "~") && strings.ContainsAny(name, " \t;{}") {
+		return `"` + name + `"`
+	}
+	return name
+}
+
+// SetListen sets server names and listen addresses of s, empty ones are
+// derived from ServerName
+func (s *NginxServer) SetListen(names []string, listens []*Listen) error {
+	for _, n := range names {
+		if err := validateServerName(n); err != nil {
+			return err
+		}
+	}
+	for _, l := range listens {
+		if err := l.Validate(); err != nil {
+			return err
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if tls := s.TLS; tls != nil && tls.Redirect {
+		for _, l := range listens {
+			if l.Port == 80 {
+				return fmt.Errorf("cannot redirect to https on port 80")
+			}
+		}
+	}
+
+	oldNames, oldListens := s.Names, s.Listens
+	s.Names, s.Listens = names, listens
+	if c := s.TLS; c != nil && c.Source != TLSSourceFile && s.primaryName() == "" {
+		s.Names, s.Listens = oldNames, oldListens
+		return fmt.Errorf("cannot manage certificate without server name")
+	}
+	if addr := s.duplicateListen(); addr != "" {
+		s.Names, s.Listens = oldNames, oldListens
+		return fmt.Errorf("listen on %s more than once", addr)
+	}
+	return nil
+}
+
+// names returns server names of s, caller must hold lock of s
+func (s *NginxServer) names() []string {
+	if len(s.Names) > 0 {
+		return s.Names
+	}
+	if host, _ := splitName(s.ServerName); host != "" {
+		return []string{host}
+	}
+	return nil
+}
+
+// primaryName returns first server name without wildcard, used as host name
+// of certificate. Caller must hold lock of s.
+func (s *NginxServer) primaryName() string {
+	if names := s.certNames(); len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
+
+// certNames returns server names without wildcard or regular expression, which
+// are put in certificate. Caller must hold lock of s.
+func (s *NginxServer) certNames() []string {
+	var ret []string
+	for _, n := range s.names() {
+		if !strings.HasPrefix(n, "~") && !strings.Contains(n, "*") && !strings.HasPrefix(n, ".") {
+			ret = append(ret, n)
+		}
+	}
+	return ret
+}
+
+// listensOn reports if port is explicitly set in s, caller must hold lock of s
+func (s *NginxServer) listensOn(port int) bool {
+	if len(s.Listens) == 0 {
+		_, p := splitName(s.ServerName)
+		return p == strconv.Itoa(port)
+	}
+	for _, l := range s.Listens {
+		if l.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// duplicateListen returns address which s listens on more than once, empty if
+// none. Caller must hold lock of s.
+func (s *NginxServer) duplicateListen() string {
+	seen := map[string]bool{}
+	for _, l := range s.listens() {
+		addr := l.addr()
+		if seen[addr] {
+			return addr
+		}
+		seen[addr] = true
+	}
+	return ""
+}
+
+// listens returns listen addresses of s with port filled, caller must hold
+// lock of s
+func (s *NginxServer) listens() []*Listen {
+	port := 80
+	if s.TLS != nil {
+		port = 443
+	}
+
+	if len(s.Listens) == 0 {
+		l := &Listen{Port: port, Default: s.ServerName == ""}
+		if _, p := splitName(s.ServerName); p != "" {
+			l.Port, _ = strconv.Atoi(p)
+		}
+		return []*Listen{l}
+	}
+
+	ret := make([]*Listen, 0, len(s.Listens))
+	for _, l := range s.Listens {
+		copied := *l
+		if copied.Port == 0 {
+			copied.Port = port
+		}
+		ret = append(ret, &copied)
+	}
+	return ret
+}
@@ -0,0 +1,188 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import "testing"
+
+func TestSplitName(t *testing.T) {
+	cases := map[string][2]string{
+		"":                   {"", ""},
+		"example.com":        {"example.com", ""},
+		"example.com:8080":   {"example.com", "8080"},
+		":8080":              {"", "8080"},
+		"[::1]:8080":         {"::1", "8080"},
+		"::1":                {"::1", ""},
+		"[2001:db8::1]":      {"2001:db8::1", ""},
+		"127.0.0.1:8080":     {"127.0.0.1", "8080"},
+		"*.example.com:8443": {"*.example.com", "8443"},
+	}
+	for name, expect := range cases {
+		if host, port := splitName(name); host != expect[0] || port != expect[1] {
+			t.Errorf("splitName(%q): expect %v, got %s %s", name, expect, host, port)
+		}
+	}
+}
+
+func TestListenValidate(t *testing.T) {
+	valid := []*Listen{
+		{},
+		{Port: 8080, Default: true},
+		{Address: "127.0.0.1", Port: 80},
+		{Address: "::1", Port: 443, IPv6: true},
+		{IPv6: true},
+	}
+	for _, l := range valid {
+		if err := l.Validate(); err != nil {
+			t.Errorf("Valid listen %#v is rejected: %s", l, err)
+		}
+	}
+
+	invalid := []*Listen{
+		{Port: -1},
+		{Port: 65536},
+		{Address: "localhost"},
+		{Address: "127.0.0.1", IPv6: true},
+	}
+	for _, l := range invalid {
+		if l.Validate() == nil {
+			t.Errorf("Invalid listen %#v is accepted", l)
+		}
+	}
+}
+
+func TestValidateServerName(t *testing.T) {
+	for _, n := range []string{"example.com", "*.example.com", "www.example.*", ".example.com", `~^www\d+\.example\.com$`, "_"} {
+		if err := validateServerName(n); err != nil {
+			t.Errorf("Valid server name %s is rejected: %s", n, err)
+		}
+	}
+	for _, n := range []string{"", "a b", "example.com;", "*.*.example.com", "www.*.com", "example.com:80", `~^(www$`} {
+		if validateServerName(n) == nil {
+			t.Errorf("Invalid server name %s is accepted", n)
+		}
+	}
+}
+
+func TestListenExport(t *testing.T) {
+	expect := `server {
+    server_name example.com *.example.com "~^(\w+){2}\.example\.org$";
+    listen 127.0.0.1:80;
+    listen [::]:80;
+    return 301 https://$host$request_uri;
+}
+
+server {
+    client_max_body_size 250m;
+    server_name example.com *.example.com "~^(\w+){2}\.example\.org$";
+    listen 127.0.0.1:443 ssl http2;
+    listen [::]:443 ssl http2 default_server;
+    ssl_certificate /etc/ssl/a.crt;
+    ssl_certificate_key /etc/ssl/a.key;
+
+}`
+
+	s := NewServer("example.com")
+	err := s.SetListen(
+		[]string{"example.com", "*.example.com", `~^(\w+){2}\.example\.org$`},
+		[]*Listen{{Address: "127.0.0.1"}, {IPv6: true, Default: true}},
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	s.SetTLS(&TLSConfig{Certificate: "/etc/ssl/a.crt", Key: "/etc/ssl/a.key", Redirect: true})
+	if actual := s.Export(); actual != expect {
+		t.Errorf("Listen returns %s", actual)
+	}
+}
+
+func TestListenLegacyIPv6(t *testing.T) {
+	expect := `server {
+    client_max_body_size 250m;
+    server_name ::1;
+    listen 8080;
+
+}`
+
+	if actual := NewServer("[::1]:8080").Export(); actual != expect {
+		t.Errorf("Legacy IPv6 name returns %s", actual)
+	}
+}
+
+func TestListenRedirectOnHTTPPort(t *testing.T) {
+	s := NewServer("example.com")
+	s.SetListen(nil, []*Listen{{Port: 80}, {Port: 443}})
+	err := s.SetTLS(&TLSConfig{Certificate: "/etc/ssl/a.crt", Key: "/etc/ssl/a.key", Redirect: true})
+	if err == nil {
+		t.Error("Redirecting to https on port 80 should be rejected")
+	}
+
+	s = NewServer("example.com")
+	s.SetTLS(&TLSConfig{Certificate: "/etc/ssl/a.crt", Key: "/etc/ssl/a.key", Redirect: true})
+	if s.SetListen(nil, []*Listen{{Port: 80}}) == nil {
+		t.Error("Listening on port 80 with https redirect should be rejected")
+	}
+}
+
+func TestListenDuplicate(t *testing.T) {
+	s := NewServer("example.com")
+	if s.SetListen(nil, []*Listen{{Port: 8080}, {Port: 8080, Default: true}}) == nil {
+		t.Error("Listening on same address twice should be rejected")
+	}
+	if s.SetListen(nil, []*Listen{{}, {Port: 80}}) == nil {
+		t.Error("Listening on default port twice should be rejected")
+	}
+	if err := s.SetListen(nil, []*Listen{{}, {Port: 443}}); err != nil {
+		t.Fatalf("Listening on port 80 and 443 is rejected: %s", err)
+	}
+	if s.SetTLS(&TLSConfig{Certificate: "/etc/ssl/a.crt", Key: "/etc/ssl/a.key"}) == nil {
+		t.Error("Enabling https should be rejected if default port is listened twice")
+	}
+}
+
+func TestListenPersistor(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	if srv, _ := p.SetListen("test.server", []string{"a.example.com"}, nil); srv != nil {
+		t.Error("Setting listen of unknown server should return nil")
+	}
+
+	p.Create("test.server", "/", "http://127.0.0.1", "")
+	srv, err := p.SetListen("test.server", []string{"a.example.com", "b.example.com"}, []*Listen{{Port: 8080}})
+	if err != nil || srv == nil {
+		t.Fatalf("Unexpected result: %v %s", srv, err)
+	}
+	if _, err = p.SetListen("test.server", []string{"a b"}, nil); err == nil {
+		t.Error("Invalid server name should be rejected")
+	}
+
+	loader := NewPersistor(p.filename, p.conffile)
+	if err = loader.Load(); err != nil {
+		t.Fatalf("Cannot load saved data: %s", err)
+	}
+	s := loader.List()["test.server"]
+	if s == nil || len(s.Names) != 2 || len(s.Listens) != 1 || s.Listens[0].Port != 8080 {
+		t.Errorf("Listen settings are not saved: %#v", s)
+	}
+}
+
+func TestListenDefaultServer(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	p.Create("a.example.com", "/", "http://127.0.0.1", "")
+	p.Create("b.example.com", "/", "http://127.0.0.1", "")
+	if _, err := p.SetListen("a.example.com", nil, []*Listen{{Port: 80, Default: true}}); err != nil {
+		t.Fatalf("Cannot set default server: %s", err)
+	}
+	if _, err := p.SetListen("b.example.com", nil, []*Listen{{Port: 80, Default: true}}); err != ErrDefaultServer {
+		t.Errorf("Second default server on same address should be rejected, got %v", err)
+	}
+	if srv := p.List()["b.example.com"]; len(srv.Listens) != 0 {
+		t.Errorf("Listen settings should be kept when rejected, got %#v", srv.Listens)
+	}
+	if _, err := p.SetListen("b.example.com", nil, []*Listen{{Port: 80, IPv6: true, Default: true}}); err != nil {
+		t.Errorf("Default server on another address should be accepted: %s", err)
+	}
+}
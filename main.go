@@ -8,34 +8,72 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"time"
 
 	"github.com/Patrolavia/toolkit/session"
 )
 
 func main() {
 	var (
-		data   string
-		port   string
-		ngconf string
-		fend   string
-		pass   string
-		debug  bool
+		data     string
+		port     string
+		ngconf   string
+		stconf   string
+		cacheDir string
+		fend     string
+		pass     string
+		key      string
+		tmpl     string
+		acmeURL  string
+		acmeMail string
+		debug    bool
 	)
 	flag.StringVar(&data, "data", "/var/lib/cheesecake/data.json", "path to store mapping")
 	flag.StringVar(&port, "addr", ":8080", "address to listen")
 	flag.StringVar(&ngconf, "conf", "/etc/nginx/sites-enabled/default", "path to nginx config")
+	flag.StringVar(&stconf, "stream-conf", "", "path to nginx config included in stream context, stream mappings are disabled if not set")
+	flag.StringVar(&cacheDir, "cache-dir", defaultCacheDir, "path to directory holding cached responses, must be writable by nginx workers")
 	flag.StringVar(&fend, "fe", ".", "Path to directory holding frontend files")
+	flag.StringVar(&acmeURL, "acme", LetsEncryptURL, "directory url of ACME CA")
+	flag.StringVar(&acmeMail, "acme-email", "", "contact email of ACME account")
+	flag.StringVar(&tmpl, "tmpl", "", "path to directory holding custom nginx config templates (*.tmpl)")
 	flag.StringVar(&pass, "pass", "", "password to lock the manage page")
+	flag.StringVar(&key, "keyfile", "", "path to key file encrypting secrets, "+SecretKeyEnv+" environment variable is used if not set")
 	flag.BoolVar(&debug, "debug", false, "debug mode")
 	flag.Parse()
 
 	p := NewPersistor(data, ngconf)
+	p.SetStreamFile(stconf)
+	p.SetCacheDir(cacheDir)
+	box, err := LoadSecretBox(key, os.Getenv(SecretKeyEnv))
+	if err != nil {
+		log.Fatalf("Cannot load secret key: %s", err)
+	}
+	p.SetSecretBox(box)
+	renderer, err := NewRenderer(tmpl)
+	if err != nil {
+		log.Fatalf("Cannot load templates from %s: %s", tmpl, err)
+	}
+	p.SetRenderer(renderer)
+	certs := filepath.Join(filepath.Dir(data), "certs")
+	ca, err := OpenLocalCA(certs)
+	if err != nil {
+		log.Fatalf("Cannot open local CA in %s: %s", certs, err)
+	}
+	p.SetIssuer(TLSSourceLocal, ca)
+	acmeMgr, err := OpenACME(filepath.Join(certs, "acme"), filepath.Join(filepath.Dir(data), "acme-challenge"), acmeURL, acmeMail)
+	if err != nil {
+		log.Fatalf("Cannot open ACME account in %s: %s", certs, err)
+	}
+	p.SetIssuer(TLSSourceACME, acmeMgr)
 	if err := p.Load(); err != nil {
 		log.Fatalf("Cannot load data from %s: %s", data, err)
 	}
 
-	tmpl, err := ioutil.ReadFile(fend + "/index.html")
+	index, err := ioutil.ReadFile(fend + "/index.html")
 	if err != nil {
 		log.Fatalf("Cannot read index page from %s/index.html: %s", fend, err)
 	}
@@ -54,16 +92,48 @@ func main() {
 	h := Handler{
 		p,
 		f,
+		ca,
 	}
+	go func() {
+		for {
+			h.renewCertificates()
+			time.Sleep(12 * time.Hour)
+		}
+	}()
+
 	http.HandleFunc("/api/list", h.List)
 	http.HandleFunc("/api/create", h.Create)
 	http.HandleFunc("/api/modify", h.Modify)
 	http.HandleFunc("/api/delete", h.Delete)
 	http.HandleFunc("/api/enable", h.Enable)
 	http.HandleFunc("/api/disable", h.Disable)
+	http.HandleFunc("/api/servers", h.Servers)
+	http.HandleFunc("/api/tls", h.TLS)
+	http.HandleFunc("/api/ca.crt", h.CACert)
+	http.HandleFunc("/api/pools", h.Pools)
+	http.HandleFunc("/api/pools/save", h.SavePool)
+	http.HandleFunc("/api/pools/delete", h.DeletePool)
+	http.HandleFunc("/api/pools/member", h.PoolMember)
+	http.HandleFunc("/api/auth", h.Auth)
+	http.HandleFunc("/api/credentials", h.Credentials)
+	http.HandleFunc("/api/credentials/user", h.SetUser)
+	http.HandleFunc("/api/credentials/delete_user", h.DeleteUser)
+	http.HandleFunc("/api/credentials/delete", h.DeleteCredentials)
+	http.HandleFunc("/api/access", h.Access)
+	http.HandleFunc("/api/tuning", h.Tuning)
+	http.HandleFunc("/api/listen", h.Listen)
+	http.HandleFunc("/api/lists", h.AddressLists)
+	http.HandleFunc("/api/lists/save", h.SaveAddressList)
+	http.HandleFunc("/api/lists/delete", h.DeleteAddressList)
+	http.HandleFunc("/api/cache/purge", h.PurgeCache)
+	http.HandleFunc("/api/overrides/set", h.SetOverride)
+	http.HandleFunc("/api/overrides/delete", h.DeleteOverride)
+	http.HandleFunc("/api/streams", h.Streams)
+	http.HandleFunc("/api/streams/save", h.SaveStream)
+	http.HandleFunc("/api/streams/delete", h.DeleteStream)
 
 	rootHandler := func(w http.ResponseWriter, r *http.Request) {
-		w.Write(tmpl)
+		w.Write(index)
 	}
 	realRoot := rootHandler
 	loginPage, err := ioutil.ReadFile(fend + "/login.html")
@@ -0,0 +1,130 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+)
+
+// how location path is matched
+const (
+	MatchPrefix   = "prefix"
+	MatchExact    = "exact"
+	MatchRegex    = "regex"
+	MatchIRegex   = "iregex"
+	MatchPriority = "priority"
+)
+
+// matchModifiers maps match kinds to nginx location modifiers
+var matchModifiers = map[string]string{
+	"":            "",
+	MatchPrefix:   "",
+	MatchExact:    "=",
+	MatchRegex:    "~",
+	MatchIRegex:   "~*",
+	MatchPriority: "^~",
+}
+
+// isRegexMatch reports whether kind matches path as regular expression
+func isRegexMatch(kind string) bool {
+	return kind == MatchRegex || kind == MatchIRegex
+}
+
+// validateMatch checks if path can be used with match kind
+func validateMatch(kind, path string) error {
+	if _, ok := matchModifiers[kind]; !ok {
+		return fmt.Errorf("unknown match kind %s", kind)
+	}
+
+	if isRegexMatch(kind) {
+		if err := checkRegex(path); err != nil {
+			return fmt.Errorf("invalid regular expression %s: %s", path, err)
+		}
+		return nil
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("path %s should start with /", path)
+	}
+	if strings.ContainsAny(path, " \t\n;{}\"'") {
+		return fmt.Errorf("path %s contains invalid characters", path)
+	}
+	return nil
+}
+
+// splitMatch extracts match kind from path like "= /health", kind is empty
+// if path has no modifier.
+func splitMatch(path string) (kind, rest string) {
+	arr := strings.SplitN(path, " ", 2)
+	if len(arr) != 2 {
+		return "", path
+	}
+
+	for k, mod := range matchModifiers {
+		if mod != "" && mod == arr[0] {
+			return k, strings.TrimSpace(arr[1])
+		}
+	}
+	return "", path
+}
+
+// mappingKey returns key of mapping at path in Paths of server, which is path
+// prefixed by location modifier like "= /health", so a path can be mapped
+// with different match kinds.
+func mappingKey(kind, path string) string {
+	if mod := matchModifiers[kind]; mod != "" {
+		return mod + " " + path
+	}
+	return path
+}
+
+// mappingKeys sorts keys of mappings in order of locations in config. Regex
+// locations are checked by nginx in order of config, so they come last,
+// sorted by Order.
+type mappingKeys struct {
+	keys  []string
+	paths map[string]*Mapping
+}
+
+func (k mappingKeys) Len() int { return len(k.keys) }
+func (k mappingKeys) Less(i, j int) bool {
+	a, b := k.paths[k.keys[i]], k.paths[k.keys[j]]
+	if ra, rb := isRegexMatch(a.Match), isRegexMatch(b.Match); ra != rb {
+		return rb
+	}
+	if isRegexMatch(a.Match) && a.Order != b.Order {
+		return a.Order < b.Order
+	}
+	return k.keys[i] < k.keys[j]
+}
+func (k mappingKeys) Swap(i, j int) { k.keys[i], k.keys[j] = k.keys[j], k.keys[i] }
+
+// locationArgs returns arguments of location directive
+func locationArgs(kind, path string) string {
+	if isRegexMatch(kind) && strings.ContainsAny(path, " \t;{}") {
+		path = `"` + path + `"`
+	}
+
+	if mod := matchModifiers[kind]; mod != "" {
+		return mod + " " + path
+	}
+	return path
+}
+
+// checkRegex checks syntax of regular expression used by nginx. It is parsed
+// with Perl syntax of regexp/syntax, which is a subset of PCRE, so PCRE only
+// features like lookaround and backreferences are rejected.
+func checkRegex(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty pattern")
+	}
+	if strings.ContainsAny(pattern, "\"\n") {
+		return fmt.Errorf("quotes and newlines are not allowed")
+	}
+
+	_, err := syntax.Parse(pattern, syntax.Perl)
+	return err
+}
@@ -0,0 +1,128 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import "testing"
+
+func TestCheckRegex(t *testing.T) {
+	valid := []string{
+		`^/api/(.*)$`,
+		`\.(png|jpe?g|gif)$`,
+		`^/(?P<id>\d+)$`,
+		`(?i)^/docs`,
+		`^/a{2,3}b*?`,
+		`[]a-z[:digit:]]+`,
+		`^/{$`,
+	}
+	for _, p := range valid {
+		if err := checkRegex(p); err != nil {
+			t.Errorf("Valid pattern %s is rejected: %s", p, err)
+		}
+	}
+
+	invalid := []string{
+		``,
+		`^/api/(.*$`,
+		`^/api/.*)$`,
+		`*.png`,
+		`^/(|+)`,
+		`[a-z`,
+		`(?Q)abc`,
+		`abc\`,
+		`^/a"b`,
+		// PCRE only
+		`^/(?!admin)[^/]+/`,
+		`^/(a)\1$`,
+	}
+	for _, p := range invalid {
+		if checkRegex(p) == nil {
+			t.Errorf("Invalid pattern %s is accepted", p)
+		}
+	}
+}
+
+func TestMatchValidate(t *testing.T) {
+	valid := map[string]*Mapping{
+		"/":              {Upstream: "http://a"},
+		"/healthz":       {Upstream: "http://a", Match: MatchExact},
+		"/static/":       {Upstream: "http://a", Match: MatchPriority},
+		`\.php$`:         {Upstream: "http://a", Match: MatchRegex},
+		`^/users/(\d+)$`: {Upstream: "http://a", Match: MatchIRegex, Rewrite: &Rewrite{Mode: RewriteRegex, Pattern: `^/users/(\d+)$`, Target: "/u/$1"}},
+		"/api/":          {Upstream: "http://a", Match: MatchExact, Rewrite: &Rewrite{Mode: RewriteStrip}},
+	}
+	for path, m := range valid {
+		if err := m.Validate(path); err != nil {
+			t.Errorf("Valid mapping at %s is rejected: %s", path, err)
+		}
+	}
+
+	invalid := map[string]*Mapping{
+		"/a/":    {Upstream: "http://a", Match: "glob"},
+		"/b c/":  {Upstream: "http://a"},
+		`^/(.*$`: {Upstream: "http://a", Match: MatchRegex},
+		`^/d/`:   {Upstream: "http://a", Match: MatchRegex, Rewrite: &Rewrite{Mode: RewriteStrip}},
+		`/e;`:    {Upstream: "http://a", Match: MatchExact},
+	}
+	for path, m := range invalid {
+		if m.Validate(path) == nil {
+			t.Errorf("Invalid mapping at %s is accepted", path)
+		}
+	}
+}
+
+func TestMatchExport(t *testing.T) {
+	expect := `server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 80;
+
+    location / {
+        proxy_pass http://fallback;
+        include proxy_params;
+        
+    }
+
+    location = / {
+        proxy_pass http://home;
+        include proxy_params;
+        
+    }
+
+    location ^~ /static/ {
+        proxy_pass http://static;
+        include proxy_params;
+        
+    }
+
+    location ~ ^/images/thumb/ {
+        proxy_pass http://thumbs;
+        include proxy_params;
+        
+    }
+
+    location ~* "\.(jpe?g|png){1}$" {
+        proxy_pass http://images;
+        include proxy_params;
+        
+    }
+
+}`
+
+	s := NewServer("example.com")
+	s.CreateMapping("/", &Mapping{Upstream: "http://home", Match: MatchExact})
+	s.CreateMapping("/", &Mapping{Upstream: "http://fallback"})
+	s.CreateMapping("/static/", &Mapping{Upstream: "http://static", Match: MatchPriority})
+	s.CreateMapping(`\.(jpe?g|png){1}$`, &Mapping{Upstream: "http://images", Match: MatchIRegex, Order: 2})
+	s.CreateMapping(`^/images/thumb/`, &Mapping{Upstream: "http://thumbs", Match: MatchRegex, Order: 1})
+	if actual := s.Export(); actual != expect {
+		t.Errorf("Match modifiers returns %s", actual)
+	}
+
+	if s.CreateMapping("/", &Mapping{Upstream: "http://other", Match: MatchExact}) {
+		t.Error("Mapping of same path and match should conflict")
+	}
+	if !s.Delete("^~ /static/") {
+		t.Error("Cannot delete mapping by path with match modifier")
+	}
+}
@@ -4,44 +4,246 @@
 package main
 
 import (
-	"sort"
+	"bytes"
+	"fmt"
+	"net"
 	"strings"
 	"sync"
+	"time"
 )
 
-type nginxConf struct {
-	data []string
-}
+// Mapping is base structure of path-upstream mapping
+type Mapping struct {
+	// one of Mapping* constants, proxy if empty
+	Type string `json:"type,omitempty"`
+
+	Upstream string `json:"upstream"`
+	Pool     string `json:"pool,omitempty"` // name of Pool, instead of Upstream
+	// one of Protocol* constants, derived from scheme of Upstream if empty
+	Protocol string `json:"protocol,omitempty"`
+	// directory of scripts on fastcgi server, $document_root if empty
+	ScriptRoot string `json:"script_root,omitempty"`
+	// upstreams selected by request header or cookie, instead of Upstream
+	// or Pool
+	Overrides []*Override `json:"overrides,omitempty"`
+
+	CustomTags string `json:"custom_tags"`
+	Enabled    bool   `json:"enabled"`
 
-func newConf() *nginxConf {
-	return &nginxConf{make([]string, 0, 2)}
+	// how path is matched, one of Match* constants, prefix if empty
+	Match string `json:"match,omitempty"`
+	// position of regex mapping, lower ones are checked first
+	Order int `json:"order,omitempty"`
+
+	// settings of redirect, response and static types
+	Redirect *Redirect `json:"redirect,omitempty"`
+	Response *Response `json:"response,omitempty"`
+	Static   *Static   `json:"static,omitempty"`
+
+	// proxy WebSocket connections
+	WebSocket bool `json:"websocket,omitempty"`
+	// disable buffering for streaming like server-sent events
+	Streaming bool `json:"streaming,omitempty"`
+	// proxy_read_timeout, nginx default if empty
+	ReadTimeout string `json:"read_timeout,omitempty"`
+	// timeouts, buffering and body size, overrides the ones of server
+	Tuning *Tuning `json:"tuning,omitempty"`
+
+	// headers of request and response
+	Headers *HeaderRules `json:"headers,omitempty"`
+
+	// name of CredentialSet for basic auth, or AuthOff to disable auth of
+	// server
+	Auth string `json:"auth,omitempty"`
+	// client address restriction, replaces the one of server
+	Access *Access `json:"access,omitempty"`
+
+	// request rate limit
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+
+	// cache of upstream responses
+	Cache *Cache `json:"cache,omitempty"`
+
+	// cross-origin resource sharing policy
+	CORS *CORS `json:"cors,omitempty"`
+
+	// change request uri before passing to upstream
+	Rewrite *Rewrite `json:"rewrite,omitempty"`
+
+	// encrypted values, referenced as {{secret:name}} in custom tags
+	Secrets map[string]string `json:"secrets,omitempty"`
+
+	// metadata, not used when exporting
+	Description string            `json:"description"`
+	Owner       string            `json:"owner"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
 }
 
-func (c *nginxConf) add(line string) *nginxConf {
-	c.data = append(c.data, line)
-	return c
+// Validate checks if m is malformed, path is where m is mapped to
+func (m *Mapping) Validate(path string) error {
+	if err := validateMatch(m.Match, path); err != nil {
+		return err
+	}
+	if m.Order != 0 && !isRegexMatch(m.Match) {
+		return fmt.Errorf("order is only used by regex mappings")
+	}
+
+	if err := m.validateType(path); err != nil {
+		return err
+	}
+
+	if m.Upstream != "" && m.Pool != "" {
+		return fmt.Errorf("upstream and pool cannot be used together")
+	}
+
+	if m.proxied() {
+		if err := m.validateProtocol(); err != nil {
+			return err
+		}
+	}
+
+	if err := m.validateOverrides(); err != nil {
+		return err
+	}
+
+	if m.ReadTimeout != "" && !isTime(m.ReadTimeout) {
+		return fmt.Errorf("invalid read timeout %s", m.ReadTimeout)
+	}
+
+	if m.Tuning != nil {
+		if err := m.Tuning.Validate(); err != nil {
+			return err
+		}
+		if !m.proxied() && m.Tuning.proxied() {
+			return fmt.Errorf("timeouts and buffering need upstream")
+		}
+		if m.ReadTimeout != "" && m.Tuning.ReadTimeout != "" {
+			return fmt.Errorf("read timeout is set twice")
+		}
+		if m.Streaming && (m.Tuning.Buffering != nil || m.Tuning.RequestBuffering != nil) {
+			return fmt.Errorf("streaming and buffering cannot be used together")
+		}
+	}
+
+	if m.Headers != nil {
+		if err := m.Headers.Validate(m.proxied()); err != nil {
+			return err
+		}
+	}
+
+	if m.Auth != "" && m.Auth != AuthOff && !credentialsName.MatchString(m.Auth) {
+		return fmt.Errorf("invalid credentials name %q", m.Auth)
+	}
+
+	if m.Access != nil {
+		if err := m.Access.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if m.RateLimit != nil {
+		if err := m.RateLimit.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if m.Cache != nil {
+		if !m.proxied() {
+			return fmt.Errorf("cache needs upstream")
+		}
+		if err := m.Cache.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if m.CORS != nil {
+		if err := m.CORS.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if m.Rewrite != nil {
+		if err := m.Rewrite.Validate(path); err != nil {
+			return err
+		}
+		if m.Rewrite.Mode != RewriteRegex && isRegexMatch(m.Match) {
+			return fmt.Errorf("cannot %s path matched by regular expression", m.Rewrite.Mode)
+		}
+	}
+
+	for _, ref := range secretRef.FindAllStringSubmatch(m.CustomTags, -1) {
+		if _, ok := m.Secrets[ref[1]]; !ok {
+			return fmt.Errorf("custom tags refer to undefined secret %s", ref[1])
+		}
+	}
+
+	return nil
 }
 
-func (c *nginxConf) indent(line string, level int) *nginxConf {
-	return c.add(strings.Repeat("    ", level) + line)
+// proxied reports whether m passes requests to upstream
+func (m *Mapping) proxied() bool {
+	return m.Type == "" || m.Type == MappingProxy
 }
 
-func (c *nginxConf) export() string {
-	return strings.Join(c.data, "\n")
+// validateType checks if settings of m match its type, path is where m is
+// mapped to
+func (m *Mapping) validateType(path string) error {
+	settings := map[string]bool{
+		MappingRedirect: m.Redirect != nil,
+		MappingResponse: m.Response != nil,
+		MappingStatic:   m.Static != nil,
+	}
+	for typ, ok := range settings {
+		if ok && typ != m.Type {
+			return fmt.Errorf("%s settings cannot be used in %s mapping", typ, m.typeName())
+		}
+	}
+
+	if m.proxied() {
+		return nil
+	}
+	if m.Upstream != "" || m.Pool != "" || m.Protocol != "" || m.Rewrite != nil {
+		return fmt.Errorf("upstream, pool, protocol and rewrite cannot be used in %s mapping", m.Type)
+	}
+	if _, ok := settings[m.Type]; !ok {
+		return fmt.Errorf("unknown mapping type %s", m.Type)
+	}
+	if !settings[m.Type] {
+		return fmt.Errorf("%s mapping needs %s settings", m.Type, m.Type)
+	}
+
+	switch m.Type {
+	case MappingRedirect:
+		return m.Redirect.Validate()
+	case MappingResponse:
+		return m.Response.Validate()
+	default:
+		return m.Static.Validate(m.Match, path)
+	}
 }
 
-// Mapping is base structure of path-upstream mapping
-type Mapping struct {
-	Upstream   string `json:"upstream"`
-	CustomTags string `json:"custom_tags"`
-	Enabled    bool   `json:"enabled"`
+// typeName returns type of m, proxy if not set
+func (m *Mapping) typeName() string {
+	if m.Type == "" {
+		return MappingProxy
+	}
+	return m.Type
 }
 
 // NginxServer represents server segment of nginx conf
 type NginxServer struct {
 	ServerName   string              `json:"name"`
 	Paths        map[string]*Mapping `json:"paths"`
+	TLS          *TLSConfig          `json:"tls,omitempty"`
+	Auth         string              `json:"auth,omitempty"` // name of CredentialSet
+	Access       *Access             `json:"access,omitempty"`
+	Tuning       *Tuning             `json:"tuning,omitempty"`
+	Names        []string            `json:"names,omitempty"`   // derived from ServerName if empty
+	Listens      []*Listen           `json:"listens,omitempty"` // derived from ServerName if empty
 	length       int
+	box          *SecretBox
 	sync.RWMutex `json:"-"`
 }
 
@@ -50,36 +252,87 @@ func NewServer(name string) *NginxServer {
 	return &NginxServer{
 		name,
 		map[string]*Mapping{},
+		nil,
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
 		0,
+		nil,
 		sync.RWMutex{},
 	}
 }
 
+// splitName splits "host:port" server name, port is empty if not specified.
+// IPv6 address should be enclosed in brackets if port is specified.
+func splitName(name string) (host, port string) {
+	if h, p, err := net.SplitHostPort(name); err == nil {
+		return h, p
+	}
+	// no port, or IPv6 address without port
+	return strings.TrimSuffix(strings.TrimPrefix(name, "["), "]"), ""
+}
+
 // Create new path => upstream mapping
 func (s *NginxServer) Create(path, upstream, custom string) (ok bool) {
+	return s.CreateMapping(path, &Mapping{Upstream: upstream, CustomTags: custom})
+}
+
+// CreateMapping adds m to path, m is auto-enabled. It is keyed by path with
+// modifier of m.Match, see mappingKey.
+func (s *NginxServer) CreateMapping(path string, m *Mapping) (ok bool) {
 	s.Lock()
 	defer s.Unlock()
 
-	if _, ok := s.Paths[path]; ok {
+	key := mappingKey(m.Match, path)
+	if _, ok := s.Paths[key]; ok {
 		return false
 	}
 
-	s.Paths[path] = &Mapping{upstream, custom, true}
+	for name, val := range m.Secrets {
+		if val == SecretMask {
+			delete(m.Secrets, name)
+		}
+	}
+	m.Enabled = true
+	s.Paths[key] = m
 	s.length++
 	return true
 }
 
 // Modify existing path => upstream mapping
 func (s *NginxServer) Modify(path, newPath, upstream, custom string) (ok bool) {
+	return s.ModifyMapping(path, newPath, &Mapping{Upstream: upstream, CustomTags: custom})
+}
+
+// ModifyMapping replaces mapping at key path with m, and moves it to newPath
+// matched by m.Match. Creation time of the original mapping is kept, so are masked secrets.
+func (s *NginxServer) ModifyMapping(path, newPath string, m *Mapping) (ok bool) {
 	s.Lock()
 	defer s.Unlock()
 
-	if _, ok := s.Paths[path]; !ok {
+	orig, ok := s.Paths[path]
+	if !ok {
 		return false
 	}
 
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = orig.CreatedAt
+	}
+	for name, val := range m.Secrets {
+		if val != SecretMask {
+			continue
+		}
+		if sealed, ok := orig.Secrets[name]; ok {
+			m.Secrets[name] = sealed
+		} else {
+			delete(m.Secrets, name)
+		}
+	}
+	m.Enabled = true
 	delete(s.Paths, path)
-	s.Paths[newPath] = &Mapping{upstream, custom, true}
+	s.Paths[mappingKey(m.Match, newPath)] = m
 	return true
 }
 
@@ -137,50 +390,13 @@ func (s *NginxServer) Len() int {
 	return len(s.Paths)
 }
 
-// Export to string
+// Export to string with default templates
 func (s *NginxServer) Export() string {
-	s.RLock()
-	defer s.RUnlock()
-
-	ret := newConf()
-	ret.add("server {")
-	ret.indent("client_max_body_size 250m;", 1)
-
-	arr := strings.Split(s.ServerName, ":")
-	name := arr[0]
-	port := "80"
-	if len(arr) > 1 {
-		port = arr[1]
-	}
-
-	if s.ServerName == "" {
-		ret.indent("listen "+port+" default_server;", 1)
-	} else {
-		ret.indent("server_name "+name+";", 1)
-		ret.indent("listen "+port+";", 1)
-	}
-	ret.add("")
-
-	buf := make([]string, 0, len(s.Paths))
-
-	for path := range s.Paths {
-		buf = append(buf, path)
-	}
-	sort.Strings(buf)
-
-	for _, path := range buf {
-		mapping := s.Paths[path]
-		if !mapping.Enabled {
-			continue
-		}
-		ret.indent("location "+path+" {", 1)
-		ret.indent("proxy_pass "+mapping.Upstream+";", 2)
-		ret.indent("include proxy_params;", 2)
-		ret.indent(mapping.CustomTags, 2)
-		ret.indent("}", 1)
-		ret.add("")
+	buf := &bytes.Buffer{}
+	if err := defaultRenderer.Server(buf, s); err != nil {
+		// default templates are tested, this should not happen
+		panic(err)
 	}
 
-	ret.add("}")
-	return ret.export()
+	return buf.String()
 }
@@ -3,7 +3,11 @@
 
 package main
 
-import "testing"
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
 
 func TestNginxExportNoname(t *testing.T) {
 	expect := `server {
@@ -93,3 +97,53 @@ func TestNginxExportCustomPort(t *testing.T) {
 		t.Errorf("Noname returns %s", actual)
 	}
 }
+
+func TestNginxExportWebSocket(t *testing.T) {
+	expect := `map $http_upgrade $connection_upgrade {
+    default upgrade;
+    '' close;
+}
+
+server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 80;
+
+    location /events/ {
+        proxy_pass http://events;
+        include proxy_params;
+        proxy_buffering off;
+        proxy_request_buffering off;
+        proxy_read_timeout 1h;
+        
+    }
+
+    location /ws/ {
+        proxy_pass http://ws;
+        include proxy_params;
+        proxy_http_version 1.1;
+        proxy_set_header Upgrade $http_upgrade;
+        proxy_set_header Connection $connection_upgrade;
+        
+    }
+
+}
+`
+
+	s := NewServer("example.com")
+	s.CreateMapping("/ws/", &Mapping{Upstream: "http://ws", WebSocket: true})
+	s.CreateMapping("/events/", &Mapping{Upstream: "http://events", Streaming: true, ReadTimeout: "1h"})
+	buf := &bytes.Buffer{}
+	defaultRenderer.Config(buf, &Dataset{Servers: []*NginxServer{s}})
+
+	if actual := buf.String(); actual != expect {
+		t.Errorf("WebSocket returns %s", actual)
+	}
+
+	s.Disable("/ws/")
+	buf.Reset()
+	defaultRenderer.Config(buf, &Dataset{Servers: []*NginxServer{s}})
+	if strings.Contains(buf.String(), "$connection_upgrade {") {
+		t.Error("Upgrade map should not be rendered without enabled WebSocket mapping")
+	}
+}
@@ -0,0 +1,296 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// cookieName matches cookie names usable in nginx variables
+var cookieName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// overrideValue matches values selecting an Override, like "alice"
+var overrideValue = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+
+// mapKeywords cannot be used as source values in map blocks
+var mapKeywords = map[string]bool{
+	"default":   true,
+	"hostnames": true,
+	"include":   true,
+	"volatile":  true,
+}
+
+// Override passes requests having a header or cookie of Value to Upstream
+// instead, like personal backend of a developer
+type Override struct {
+	// request header like "X-Dev-Route", or cookie name
+	Header string `json:"header,omitempty"`
+	Cookie string `json:"cookie,omitempty"`
+	Value  string `json:"value"`
+
+	// like "http://10.0.0.23:8080" or name of Pool, scheme follows protocol
+	// of the mapping
+	Upstream string `json:"upstream"`
+
+	// metadata, not used when exporting
+	Owner string `json:"owner,omitempty"`
+}
+
+// Validate checks if o is malformed
+func (o *Override) Validate() error {
+	switch {
+	case o.Header != "" && o.Cookie != "":
+		return fmt.Errorf("override is selected by header or cookie, not both")
+	case o.Header != "":
+		if !headerName.MatchString(o.Header) {
+			return fmt.Errorf("invalid header name %q", o.Header)
+		}
+	case o.Cookie != "":
+		if !cookieName.MatchString(o.Cookie) {
+			return fmt.Errorf("invalid cookie name %q", o.Cookie)
+		}
+	default:
+		return fmt.Errorf("override needs a header or cookie")
+	}
+
+	if !overrideValue.MatchString(o.Value) || mapKeywords[o.Value] {
+		return fmt.Errorf("invalid override value %q", o.Value)
+	}
+
+	if o.Upstream == "" || strings.ContainsAny(o.Upstream, " \t\n;{}\"'$") {
+		return fmt.Errorf("invalid override upstream %q", o.Upstream)
+	}
+	return nil
+}
+
+// source returns nginx variable selecting o
+func (o *Override) source() string {
+	if o.Header != "" {
+		return headerVariable(o.Header)
+	}
+	return "$cookie_" + o.Cookie
+}
+
+// sameKey reports whether o and x are selected by same header or cookie value
+func (o *Override) sameKey(x *Override) bool {
+	return o.source() == x.source() && o.Value == x.Value
+}
+
+// validateOverrides checks overrides of m
+func (m *Mapping) validateOverrides() error {
+	if len(m.Overrides) == 0 {
+		return nil
+	}
+	if !m.proxied() {
+		return fmt.Errorf("overrides need upstream")
+	}
+	if _, uri := splitUpstream(m.pass()); uri != "" && m.Rewrite == nil {
+		return fmt.Errorf("upstream cannot contain uri when using overrides, use rewrite instead")
+	}
+
+	for i, o := range m.Overrides {
+		if err := o.Validate(); err != nil {
+			return err
+		}
+		for _, x := range m.Overrides[:i] {
+			if o.sameKey(x) {
+				return fmt.Errorf("override %s of %s is set more than once", o.Value, o.source())
+			}
+		}
+
+		switch m.module() {
+		case "fastcgi", "uwsgi":
+			if !isSocketAddress(o.Upstream) && !poolName.MatchString(o.Upstream) {
+				return fmt.Errorf("invalid %s override upstream %q", m.protocol(), o.Upstream)
+			}
+			continue
+		}
+		if scheme, _ := splitScheme(o.Upstream); scheme != "" && scheme != m.protocol() {
+			return fmt.Errorf("override upstream %s does not speak %s", o.Upstream, m.protocol())
+		}
+		if _, uri := splitUpstream(m.passOf(o.Upstream)); uri != "" {
+			return fmt.Errorf("override upstream %s cannot contain uri", o.Upstream)
+		}
+	}
+	return nil
+}
+
+// overrideEntry is an item of map block
+type overrideEntry struct {
+	Value string
+	Pass  string
+}
+
+// overrideRule is the data passed to "override" template, a map block
+// selecting pass by Source
+type overrideRule struct {
+	Source   string
+	Variable string
+	// pass if no entry matches, the base one or variable of next rule
+	Default string
+	Entries []*overrideEntry
+}
+
+// overrideRules renders overrides of m into chained map blocks, one for each
+// header or cookie, headers take precedence. id identifies the location, and
+// pass is the base one. Variable of first rule is used as pass.
+//
+// Since pass is selected at runtime, nginx resolves host names in it with a
+// resolver, which is not configured. So every host name other than pools is
+// put into an implicit upstream segment, which is returned as Pool.
+func (m *Mapping) overrideRules(id, pass string, pools map[string]*Pool) ([]*overrideRule, []*Pool) {
+	var implicit []*Pool
+	if m.Pool == "" {
+		var p *Pool
+		if pass, p = m.resolveOverride(pass, "override_"+id); p != nil {
+			implicit = append(implicit, p)
+		}
+	}
+
+	bySource := map[string]*overrideRule{}
+	var ret []*overrideRule
+	for i, o := range m.Overrides {
+		rule, ok := bySource[o.source()]
+		if !ok {
+			rule = &overrideRule{Source: o.source()}
+			bySource[o.source()] = rule
+			ret = append(ret, rule)
+		}
+		var target string
+		if _, ok := pools[o.Upstream]; ok && isPoolLike(o.Upstream) {
+			target = m.passOf(poolPrefix + o.Upstream)
+		} else {
+			var p *Pool
+			target, p = m.resolveOverride(m.passOf(o.Upstream), "override_"+id+"_"+strconv.Itoa(i))
+			if p != nil {
+				implicit = append(implicit, p)
+			}
+		}
+		rule.Entries = append(rule.Entries, &overrideEntry{o.Value, target})
+	}
+	sort.Sort(overrideRulesBySource(ret))
+
+	for i, rule := range ret {
+		rule.Variable = "$upstream_" + id
+		if i > 0 {
+			rule.Variable += "_" + strconv.Itoa(i)
+		}
+		sort.Sort(overrideEntriesByValue(rule.Entries))
+	}
+	for i, rule := range ret {
+		rule.Default = pass
+		if i+1 < len(ret) {
+			rule.Default = ret[i+1].Variable
+		}
+	}
+	return ret, implicit
+}
+
+// resolveOverride returns pass usable in variable. Host name in pass is put
+// into an implicit pool of name, which is returned.
+func (m *Mapping) resolveOverride(pass, name string) (string, *Pool) {
+	scheme, target := splitScheme(pass)
+	if strings.HasPrefix(target, "unix:") {
+		return pass, nil
+	}
+
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		host, port = target, "80"
+		if scheme == ProtocolHTTPS || scheme == ProtocolGRPCS {
+			port = "443"
+		}
+	}
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return pass, nil
+	}
+
+	p := &Pool{Name: name, Servers: []*PoolServer{{Address: net.JoinHostPort(host, port)}}}
+	return m.passOf(p.UpstreamName()), p
+}
+
+// isPoolLike reports whether override upstream u refers to a pool, which is
+// a bare name without scheme and port
+func isPoolLike(u string) bool {
+	return poolName.MatchString(u) && net.ParseIP(u) == nil
+}
+
+type overrideRulesBySource []*overrideRule
+
+func (s overrideRulesBySource) Len() int { return len(s) }
+func (s overrideRulesBySource) Less(i, j int) bool {
+	// headers before cookies
+	hi, hj := strings.HasPrefix(s[i].Source, "$http_"), strings.HasPrefix(s[j].Source, "$http_")
+	if hi != hj {
+		return hi
+	}
+	return s[i].Source < s[j].Source
+}
+func (s overrideRulesBySource) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+type overrideEntriesByValue []*overrideEntry
+
+func (s overrideEntriesByValue) Len() int           { return len(s) }
+func (s overrideEntriesByValue) Less(i, j int) bool { return s[i].Value < s[j].Value }
+func (s overrideEntriesByValue) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// SetOverride adds o to mapping at key path, or replaces the one selected by
+// same header or cookie value. It returns nil if mapping is not found.
+func (s *NginxServer) SetOverride(path string, o *Override) (*Mapping, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	m, ok := s.Paths[path]
+	if !ok {
+		return nil, nil
+	}
+
+	orig := m.Overrides
+	m.Overrides = make([]*Override, 0, len(orig)+1)
+	replaced := false
+	for _, x := range orig {
+		if x.sameKey(o) {
+			x, replaced = o, true
+		}
+		m.Overrides = append(m.Overrides, x)
+	}
+	if !replaced {
+		m.Overrides = append(m.Overrides, o)
+	}
+
+	_, raw := splitMatch(path)
+	if err := m.Validate(raw); err != nil {
+		m.Overrides = orig
+		return nil, err
+	}
+	return m, nil
+}
+
+// DeleteOverride removes override selected by header or cookie value of o
+// from mapping at path. It returns nil if mapping or override is not found.
+func (s *NginxServer) DeleteOverride(path string, o *Override) *Mapping {
+	s.Lock()
+	defer s.Unlock()
+
+	m, ok := s.Paths[path]
+	if !ok {
+		return nil
+	}
+
+	for i, x := range m.Overrides {
+		if x.sameKey(o) {
+			m.Overrides = append(m.Overrides[:i:i], m.Overrides[i+1:]...)
+			if len(m.Overrides) == 0 {
+				m.Overrides = nil
+			}
+			return m
+		}
+	}
+	return nil
+}
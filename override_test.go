@@ -0,0 +1,210 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestOverrideValidate(t *testing.T) {
+	valid := []*Mapping{
+		{Upstream: "http://orders", Overrides: []*Override{
+			{Header: "X-Dev-Route", Value: "alice", Upstream: "http://10.0.0.23:8080"},
+			{Header: "X-Dev-Route", Value: "bob", Upstream: "10.0.0.24:8080"},
+			{Cookie: "dev_route", Value: "alice", Upstream: "orders-alice"},
+		}},
+		{Upstream: "http://orders/api/", Rewrite: &Rewrite{Mode: RewriteStrip}, Overrides: []*Override{
+			{Header: "X-Dev-Route", Value: "alice", Upstream: "http://10.0.0.23:8080"},
+		}},
+		{Protocol: ProtocolFastCGI, Upstream: "php:9000", Overrides: []*Override{
+			{Cookie: "dev", Value: "alice", Upstream: "unix:/run/php-alice.sock"},
+		}},
+	}
+	for _, m := range valid {
+		if err := m.Validate("/svc/"); err != nil {
+			t.Errorf("Valid overrides %#v is rejected: %s", m.Overrides, err)
+		}
+	}
+
+	one := func(o *Override) []*Override { return []*Override{o} }
+	invalid := []*Mapping{
+		{Upstream: "http://a", Overrides: one(&Override{Value: "alice", Upstream: "http://b"})},
+		{Upstream: "http://a", Overrides: one(&Override{Header: "X-A", Cookie: "a", Value: "alice", Upstream: "http://b"})},
+		{Upstream: "http://a", Overrides: one(&Override{Header: "X A", Value: "alice", Upstream: "http://b"})},
+		{Upstream: "http://a", Overrides: one(&Override{Cookie: "dev-route", Value: "alice", Upstream: "http://b"})},
+		{Upstream: "http://a", Overrides: one(&Override{Header: "X-A", Value: "default", Upstream: "http://b"})},
+		{Upstream: "http://a", Overrides: one(&Override{Header: "X-A", Value: "~.*", Upstream: "http://b"})},
+		{Upstream: "http://a", Overrides: one(&Override{Header: "X-A", Value: "alice", Upstream: ""})},
+		{Upstream: "http://a", Overrides: one(&Override{Header: "X-A", Value: "alice", Upstream: "http://b;"})},
+		{Upstream: "http://a", Overrides: one(&Override{Header: "X-A", Value: "alice", Upstream: "https://b"})},
+		{Upstream: "http://a", Overrides: one(&Override{Header: "X-A", Value: "alice", Upstream: "http://b/api"})},
+		{Upstream: "http://a/api/", Overrides: one(&Override{Header: "X-A", Value: "alice", Upstream: "http://b"})},
+		{Upstream: "http://a", Overrides: []*Override{
+			{Header: "X-A", Value: "alice", Upstream: "http://b"},
+			{Header: "x-a", Value: "alice", Upstream: "http://c"},
+		}},
+		{Type: MappingResponse, Response: &Response{Status: 204}, Overrides: one(&Override{Header: "X-A", Value: "alice", Upstream: "http://b"})},
+	}
+	for _, m := range invalid {
+		if m.Validate("/svc/") == nil {
+			t.Errorf("Invalid overrides %#v is accepted", m.Overrides[0])
+		}
+	}
+}
+
+func TestOverrideConfig(t *testing.T) {
+	s := NewServer("example.com")
+	s.CreateMapping("/api/orders/", &Mapping{
+		Upstream: "http://orders",
+		Overrides: []*Override{
+			{Cookie: "dev_route", Value: "alice", Upstream: "http://10.0.0.23:8080"},
+			{Header: "X-Dev-Route", Value: "bob", Upstream: "http://10.0.0.24:8080"},
+			{Header: "X-Dev-Route", Value: "alice", Upstream: "10.0.0.23:8080"},
+		},
+	})
+	v := "$upstream_" + locationID("example.com", "/api/orders/")
+
+	buf := &bytes.Buffer{}
+	if err := defaultRenderer.Config(buf, &Dataset{Servers: []*NginxServer{s}}); err != nil {
+		t.Fatalf("Cannot render config: %s", err)
+	}
+	actual := buf.String()
+
+	maps := `map $http_x_dev_route ` + v + ` {
+    default ` + v + `_1;
+    "alice" http://10.0.0.23:8080;
+    "bob" http://10.0.0.24:8080;
+}
+
+map $cookie_dev_route ` + v + `_1 {
+    default http://yeast_override_` + v[len("$upstream_"):] + `;
+    "alice" http://10.0.0.23:8080;
+}
+
+`
+	if !strings.HasPrefix(actual, maps) {
+		t.Errorf("Maps are not defined, got:\n%s", actual)
+	}
+	upstream := "upstream yeast_override_" + v[len("$upstream_"):] + " {\n    server orders:80;\n}\n"
+	if !strings.Contains(actual, upstream) {
+		t.Errorf("Config does not define %q:\n%s", upstream, actual)
+	}
+
+	s.SetOverride("/api/orders/", &Override{Header: "X-Pool", Value: "alice", Upstream: "orders"})
+	buf.Reset()
+	pools := []*Pool{{Name: "orders", Servers: []*PoolServer{{Address: "10.0.0.30:8080"}}}}
+	if err := defaultRenderer.Config(buf, &Dataset{Pools: pools, Servers: []*NginxServer{s}}); err != nil {
+		t.Fatalf("Cannot render config: %s", err)
+	}
+	if expect := `"alice" http://yeast_orders;`; !strings.Contains(buf.String(), expect) {
+		t.Errorf("Override to pool is not passed to %q:\n%s", expect, buf.String())
+	}
+	if expect := "    location /api/orders/ {\n        proxy_pass " + v + ";\n"; !strings.Contains(actual, expect) {
+		t.Errorf("Config does not contain %q:\n%s", expect, actual)
+	}
+}
+
+func TestOverrideResolve(t *testing.T) {
+	m := &Mapping{Upstream: "https://orders.internal"}
+	cases := []struct {
+		pass   string
+		expect string
+		pool   string
+	}{
+		{pass: "https://10.0.0.1:8443", expect: "https://10.0.0.1:8443"},
+		{pass: "https://[::1]", expect: "https://[::1]"},
+		{pass: "https://unix:/run/orders.sock", expect: "https://unix:/run/orders.sock"},
+		{pass: "https://orders.internal", expect: "https://yeast_x", pool: "orders.internal:443"},
+		{pass: "https://orders.internal:8443", expect: "https://yeast_x", pool: "orders.internal:8443"},
+	}
+	for _, c := range cases {
+		pass, p := m.resolveOverride(c.pass, "x")
+		if pass != c.expect {
+			t.Errorf("Expected %s to be passed to %s, got %s", c.pass, c.expect, pass)
+		}
+		switch {
+		case c.pool == "" && p != nil:
+			t.Errorf("Expected no implicit pool for %s, got %#v", c.pass, p)
+		case c.pool != "" && (p == nil || p.Servers[0].Address != c.pool):
+			t.Errorf("Expected implicit pool of %s for %s, got %#v", c.pool, c.pass, p)
+		}
+	}
+}
+
+func TestOverridePersistor(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	alice := &Override{Header: "X-Dev-Route", Value: "alice", Upstream: "http://10.0.0.23:8080", Owner: "alice"}
+	if _, err := p.SetOverride("example.com", "/api/", alice); err != ErrNoSuchMapping {
+		t.Errorf("Setting override of unknown server should fail, got %v", err)
+	}
+
+	p.Create("example.com", "/api/", "http://api", "")
+	if _, err := p.SetOverride("example.com", "/web/", alice); err != ErrNoSuchMapping {
+		t.Errorf("Setting override of unknown mapping should fail, got %v", err)
+	}
+	if _, err := p.SetOverride("example.com", "/api/", alice); err != nil {
+		t.Fatalf("Cannot set override: %s", err)
+	}
+
+	moved := &Override{Header: "x-dev-route", Value: "alice", Upstream: "http://10.0.0.99:8080"}
+	if _, err := p.SetOverride("example.com", "/api/", moved); err != nil {
+		t.Fatalf("Cannot replace override: %s", err)
+	}
+	bad := &Override{Header: "X-Dev-Route", Value: "bob", Upstream: "http://10.0.0.24/api"}
+	if _, err := p.SetOverride("example.com", "/api/", bad); err == nil {
+		t.Error("Invalid override should be rejected")
+	}
+
+	missing := &Override{Header: "X-Dev-Route", Value: "bob", Upstream: "orders"}
+	if _, err := p.SetOverride("example.com", "/api/", missing); err == nil {
+		t.Error("Override to unknown pool should be rejected")
+	}
+
+	p.CreateMapping("example.com", "/health", &Mapping{Upstream: "http://api", Match: MatchExact})
+	if _, err := p.SetOverride("example.com", "= /health", alice); err != nil {
+		t.Errorf("Cannot set override of exact mapping: %s", err)
+	}
+
+	m := p.List()["example.com"].List()["/api/"]
+	if len(m.Overrides) != 1 || m.Overrides[0].Upstream != "http://10.0.0.99:8080" {
+		t.Errorf("Override is not replaced, got %#v", m.Overrides)
+	}
+
+	if _, err := p.DeleteOverride("example.com", "/api/", &Override{Header: "X-Dev-Route", Value: "bob"}); err != ErrNoSuchOverride {
+		t.Errorf("Deleting unknown override should fail, got %v", err)
+	}
+	if _, err := p.DeleteOverride("example.com", "/api/", &Override{Header: "X-Dev-Route", Value: "alice"}); err != nil {
+		t.Errorf("Cannot delete override: %s", err)
+	}
+	if m := p.List()["example.com"].List()["/api/"]; m.Overrides != nil {
+		t.Errorf("Override is not deleted, got %#v", m.Overrides)
+	}
+}
+
+func TestOverridePool(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	m := &Mapping{Upstream: "http://api", Overrides: []*Override{{Header: "X-Pool", Value: "alice", Upstream: "orders"}}}
+	if _, err := p.CreateMapping("example.com", "/api/", m); err != ErrNoSuchPool {
+		t.Errorf("Override to unknown pool should be rejected, got %v", err)
+	}
+
+	p.SavePool(&Pool{Name: "orders", Servers: []*PoolServer{{Address: "10.0.0.1:8080"}}})
+	if _, err := p.CreateMapping("example.com", "/api/", m); err != nil {
+		t.Fatalf("Cannot create mapping with override to pool: %s", err)
+	}
+	if err := p.DeletePool("orders"); err != ErrPoolInUse {
+		t.Errorf("Deleting pool used by override should be rejected, got %v", err)
+	}
+
+	m = &Mapping{Upstream: "http://api", Overrides: []*Override{{Header: "X-Pool", Value: "alice", Upstream: "payments"}}}
+	if _, err := p.ModifyMapping("example.com", "/api/", "/api/", m); err != ErrNoSuchPool {
+		t.Errorf("Override to unknown pool should be rejected, got %v", err)
+	}
+}
@@ -4,18 +4,105 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+// ErrNoSuchPool is returned when deleting unknown pool, or referring to it in
+// overrides
+var ErrNoSuchPool = errors.New("no such pool")
+
+// ErrPoolInUse is returned when deleting a pool used by mappings or overrides
+var ErrPoolInUse = errors.New("pool is used by some mappings")
+
+// ErrNoSuchMember is returned when modifying unknown server of a pool
+var ErrNoSuchMember = errors.New("no such server in pool")
+
+// ErrNoSuchCredentials is returned when modifying unknown credential set
+var ErrNoSuchCredentials = errors.New("no such credentials")
+
+// ErrCredentialsInUse is returned when deleting a credential set used by
+// servers or mappings
+var ErrCredentialsInUse = errors.New("credentials are used by some servers or mappings")
+
+// ErrNoSuchList is returned when deleting unknown address list
+var ErrNoSuchList = errors.New("no such address list")
+
+// ErrListInUse is returned when deleting an address list used by servers or
+// mappings
+var ErrListInUse = errors.New("address list is used by some servers or mappings")
+
+// ErrNoSuchMapping is returned when purging cache of unknown mapping
+var ErrNoSuchMapping = errors.New("no such mapping")
+
+// ErrNoCache is returned when purging cache of a mapping without cache
+var ErrNoCache = errors.New("mapping has no cache")
+
+// ErrNoSuchStream is returned when deleting unknown stream
+var ErrNoSuchStream = errors.New("no such stream")
+
+// ErrPortInUse is returned when a port is used by both streams, or by a tcp
+// stream and a server
+var ErrPortInUse = errors.New("port is used by another stream or server")
+
+// ErrDefaultServer is returned when a server is set as default_server of an
+// address, which is default of another server already
+var ErrDefaultServer = errors.New("another server is default server of the address")
+
+// ErrSharedH2C is returned when a server with gRPC mappings but without https
+// shares a port with other servers, http2 of its listen breaks HTTP/1.1 of them
+var ErrSharedH2C = errors.New("gRPC mappings without https need a port not used by other servers")
+
+// ErrNoStreamFile is returned when saving streams without stream config file
+var ErrNoStreamFile = errors.New("stream config file is not set")
+
+// ErrMaskedSecret is returned when a secret is passed as SecretMask, but there
+// is no stored value to keep
+var ErrMaskedSecret = errors.New("masked secret has no stored value")
+
+// ErrNoSuchOverride is returned when deleting unknown upstream override
+var ErrNoSuchOverride = errors.New("no such override")
+
+// ErrNoSuchUser is returned when deleting unknown user of credential set
+var ErrNoSuchUser = errors.New("no such user in credentials")
+
+// Dataset is everything stored in data file
+type Dataset struct {
+	Servers []*NginxServer `json:"servers"`
+	Pools   []*Pool        `json:"pools,omitempty"`
+
+	Credentials  []*CredentialSet `json:"credentials,omitempty"`
+	AddressLists []*AddressList   `json:"address_lists,omitempty"`
+
+	Streams []*Stream `json:"streams,omitempty"`
+
+	// where cached responses are put
+	cacheDir string
+}
+
 // Persistor holds all server info and save/load it into disk
 type Persistor struct {
-	filename string
-	conffile string
-	servers  map[string]*NginxServer
+	filename   string
+	conffile   string
+	streamfile string
+	cachedir   string
+	servers    map[string]*NginxServer
+	pools      map[string]*Pool
+	creds      map[string]*CredentialSet
+	lists      map[string]*AddressList
+	streams    map[string]*Stream
+	box        *SecretBox
+	renderer   *Renderer
+	issuers    map[string]Issuer
 	*sync.Mutex
 }
 
@@ -24,11 +111,72 @@ func NewPersistor(fn, conf string) *Persistor {
 	return &Persistor{
 		fn,
 		conf,
+		"",
+		defaultCacheDir,
 		map[string]*NginxServer{},
+		map[string]*Pool{},
+		map[string]*CredentialSet{},
+		map[string]*AddressList{},
+		map[string]*Stream{},
+		nil,
+		defaultRenderer,
+		map[string]Issuer{},
 		&sync.Mutex{},
 	}
 }
 
+// SetRenderer sets the renderer used to write nginx config
+func (p *Persistor) SetRenderer(r *Renderer) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.renderer = r
+}
+
+// SetStreamFile sets the path to write stream config, streams cannot be saved
+// if it is not set
+func (p *Persistor) SetStreamFile(fn string) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.streamfile = fn
+}
+
+// SetCacheDir sets where cached responses are put, defaultCacheDir if not set
+func (p *Persistor) SetCacheDir(dir string) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.cachedir = dir
+}
+
+// SetSecretBox sets the key to encrypt/decrypt secrets in mappings
+func (p *Persistor) SetSecretBox(box *SecretBox) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.box = box
+	for _, srv := range p.servers {
+		srv.box = box
+	}
+}
+
+// SetIssuer sets the Issuer managing certificates of source
+func (p *Persistor) SetIssuer(source string, i Issuer) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.issuers[source] = i
+}
+
+// Seal encrypts secret values in m, it fails if m has secrets but no key is set
+func (p *Persistor) Seal(m *Mapping) error {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.box.sealMapping(m)
+}
+
 // Save configs to file
 func (p *Persistor) Save() (err error) {
 	p.Lock()
@@ -37,43 +185,136 @@ func (p *Persistor) Save() (err error) {
 	return p.doSave()
 }
 
-func (p *Persistor) doSave() (err error) {
-	buf := make([]*NginxServer, 0, len(p.servers))
-	for _, svr := range p.servers {
-		buf = append(buf, svr)
+// dataset collects all data, caller must hold the lock
+func (p *Persistor) dataset() *Dataset {
+	ret := &Dataset{
+		Servers:      make([]*NginxServer, 0, len(p.servers)),
+		Pools:        make([]*Pool, 0, len(p.pools)),
+		Credentials:  make([]*CredentialSet, 0, len(p.creds)),
+		AddressLists: make([]*AddressList, 0, len(p.lists)),
+		Streams:      make([]*Stream, 0, len(p.streams)),
+		cacheDir:     p.cacheDir(),
+	}
+	for _, srv := range p.servers {
+		ret.Servers = append(ret.Servers, srv)
+	}
+	for _, pool := range p.pools {
+		ret.Pools = append(ret.Pools, pool)
 	}
+	for _, c := range p.creds {
+		ret.Credentials = append(ret.Credentials, c)
+	}
+	for _, l := range p.lists {
+		ret.AddressLists = append(ret.AddressLists, l)
+	}
+	for _, s := range p.streams {
+		ret.Streams = append(ret.Streams, s)
+	}
+	return ret
+}
 
-	str, err := json.Marshal(buf)
+func (p *Persistor) doSave() (err error) {
+	str, err := json.Marshal(p.dataset())
 	if err != nil {
 		return
 	}
 
-	f, err := os.Create(p.filename)
-	if err != nil {
+	if err = writeFile(p.filename, bytes.NewBuffer(str)); err != nil {
 		return
 	}
-	defer f.Close()
 
-	if _, err = f.Write(str); err != nil {
+	if err = p.writeCredentials(); err != nil {
 		return
 	}
 
-	err = p.export()
+	if err = p.export(); err != nil {
+		return
+	}
+
+	err = p.exportStreams()
 
 	return
 }
 
+// credentialsDir is where htpasswd files are written
+func (p *Persistor) credentialsDir() string {
+	return filepath.Join(filepath.Dir(p.filename), "htpasswd")
+}
+
+// cacheDir is where cached responses are put
+func (p *Persistor) cacheDir() string {
+	return p.cachedir
+}
+
+// credentialsFile is path of htpasswd file of credential set name
+func (p *Persistor) credentialsFile(name string) string {
+	return filepath.Join(p.credentialsDir(), name)
+}
+
+// writeCredentials writes htpasswd files of all credential sets, caller must
+// hold the lock
+func (p *Persistor) writeCredentials() error {
+	if len(p.creds) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(p.credentialsDir(), 0755); err != nil {
+		return err
+	}
+
+	for _, c := range p.creds {
+		// read by nginx workers
+		if err := ioutil.WriteFile(c.file, c.htpasswd(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *Persistor) export() error {
-	f, err := os.Create(p.conffile)
+	buf := &bytes.Buffer{}
+	if err := p.renderer.Config(buf, p.dataset()); err != nil {
+		return err
+	}
+
+	return writeFile(p.conffile, buf)
+}
+
+// exportStreams writes stream config file if set, caller must hold the lock
+func (p *Persistor) exportStreams() error {
+	if p.streamfile == "" {
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	if err := p.renderer.Streams(buf, p.dataset()); err != nil {
+		return err
+	}
+
+	return writeFile(p.streamfile, buf)
+}
+
+// writeFile replaces file name with content of buf. It writes to a temporary
+// file and renames it, so nginx never sees a partially written file.
+func writeFile(name string, buf *bytes.Buffer) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name))
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer os.Remove(f.Name())
 
-	for _, srv := range p.servers {
-		fmt.Fprintln(f, srv.Export())
+	_, err = buf.WriteTo(f)
+	if e := f.Close(); err == nil {
+		err = e
 	}
-	return nil
+	if err == nil {
+		// read by nginx workers
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
 }
 
 // Load configs from file
@@ -86,19 +327,67 @@ func (p *Persistor) Load() (err error) {
 		return
 	}
 
-	var buf []NginxServer
-	err = json.Unmarshal(data, &buf)
+	var ds Dataset
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		// older version stores only servers
+		err = json.Unmarshal(data, &ds.Servers)
+	} else {
+		err = json.Unmarshal(data, &ds)
+	}
 	if err != nil {
 		return
 	}
 
-	p.servers = map[string]*NginxServer{}
-	for _, srv := range buf {
-		srv := srv
-		for _, mapping := range srv.Paths {
+	for _, srv := range ds.Servers {
+		for path, mapping := range srv.Paths {
 			mapping.Enabled = false
+			kind, rest := splitMatch(path)
+			if mapping.Match == "" {
+				// modifier typed into path by older version
+				mapping.Match = kind
+			}
+			if key := mappingKey(mapping.Match, rest); key != path {
+				// older version keys mappings by path only
+				if _, taken := srv.Paths[key]; !taken {
+					delete(srv.Paths, path)
+					srv.Paths[key] = mapping
+				}
+			}
+			if len(mapping.Secrets) == 0 {
+				continue
+			}
+			if p.box == nil {
+				return fmt.Errorf("%s%s has secrets, but no secret key is set", srv.ServerName, path)
+			}
+			for name, val := range mapping.Secrets {
+				if _, err := p.box.Open(val); err != nil {
+					return fmt.Errorf("cannot decrypt secret %s of %s%s: %s", name, srv.ServerName, path, err)
+				}
+			}
 		}
-		p.servers[srv.ServerName] = &srv
+		srv.box = p.box
+	}
+
+	p.servers = map[string]*NginxServer{}
+	for _, srv := range ds.Servers {
+		p.servers[srv.ServerName] = srv
+	}
+	p.pools = map[string]*Pool{}
+	for _, pool := range ds.Pools {
+		p.pools[pool.Name] = pool
+	}
+	p.creds = map[string]*CredentialSet{}
+	for _, c := range ds.Credentials {
+		c.file = p.credentialsFile(c.Name)
+		p.creds[c.Name] = c
+	}
+	p.lists = map[string]*AddressList{}
+	for _, l := range ds.AddressLists {
+		p.lists[l.Name] = l
+	}
+	p.streams = map[string]*Stream{}
+	for _, s := range ds.Streams {
+		p.streams[s.Name] = s
 	}
 
 	return
@@ -108,6 +397,7 @@ func (p *Persistor) getServer(name string) *NginxServer {
 	ret, ok := p.servers[name]
 	if !ok {
 		ret = NewServer(name)
+		ret.box = p.box
 		p.servers[name] = ret
 	}
 
@@ -115,74 +405,164 @@ func (p *Persistor) getServer(name string) *NginxServer {
 }
 
 // Create a path to upstream mapping
-func (p *Persistor) Create(name, path, upstream, custom string) (ret *NginxServer) {
+func (p *Persistor) Create(name, path, upstream, custom string) (ret *NginxServer, err error) {
+	return p.CreateMapping(name, path, &Mapping{Upstream: upstream, CustomTags: custom})
+}
+
+// CreateMapping adds m to path of server, timestamps of m are set to now. ret
+// is nil if path is taken, and ErrPortInUse is returned if new server listens
+// on port of a stream.
+func (p *Persistor) CreateMapping(name, path string, m *Mapping) (ret *NginxServer, err error) {
 	p.Lock()
 	defer p.Unlock()
-	defer p.doSave()
+
+	now := time.Now()
+	m.CreatedAt = now
+	m.UpdatedAt = now
+
+	if _, ok := p.servers[name]; !ok && p.streamOn(NewServer(name).httpPorts()) != nil {
+		return nil, ErrPortInUse
+	}
+	if p.h2cConflict(name, m.module() == "grpc") {
+		return nil, ErrSharedH2C
+	}
+	if err = p.checkLists(m.Access); err != nil {
+		return nil, err
+	}
+	if err = p.checkOverridePools(m); err != nil {
+		return nil, err
+	}
+	if err = checkMasked(m, nil); err != nil {
+		return nil, err
+	}
 
 	srv := p.getServer(name)
-	if srv.Create(path, upstream, custom) {
-		ret = srv
+	if !srv.CreateMapping(path, m) {
+		return
 	}
 
+	ret = srv
+	err = p.doSave()
 	return
 }
 
 // Modify a path to upstream mapping
-func (p *Persistor) Modify(name, path, newPath, upstream, custom string) (ret *NginxServer) {
+func (p *Persistor) Modify(name, path, newPath, upstream, custom string) (ret *NginxServer, err error) {
+	return p.ModifyMapping(name, path, newPath, &Mapping{Upstream: upstream, CustomTags: custom})
+}
+
+// ModifyMapping replaces a mapping with m, update time of m is set to now. ret
+// is nil if there's no such mapping.
+func (p *Persistor) ModifyMapping(name, path, newPath string, m *Mapping) (ret *NginxServer, err error) {
 	p.Lock()
 	defer p.Unlock()
-	defer p.doSave()
+
+	m.CreatedAt = time.Time{}
+	m.UpdatedAt = time.Now()
+
+	if p.h2cConflict(name, m.module() == "grpc") {
+		return nil, ErrSharedH2C
+	}
+	if err = p.checkLists(m.Access); err != nil {
+		return nil, err
+	}
+	if err = p.checkOverridePools(m); err != nil {
+		return nil, err
+	}
 
 	srv := p.getServer(name)
-	if srv.Modify(path, newPath, upstream, custom) {
-		ret = srv
+	srv.RLock()
+	orig := srv.Paths[path]
+	srv.RUnlock()
+	if orig != nil {
+		if err = checkMasked(m, orig.Secrets); err != nil {
+			return nil, err
+		}
+	}
+	if !srv.ModifyMapping(path, newPath, m) {
+		return
 	}
 
+	ret = srv
+	err = p.doSave()
 	return
 }
 
-// Delete a path-upstream mapping
-func (p *Persistor) Delete(name, path string) (ret *NginxServer) {
-	p.Lock()
-	defer p.Unlock()
-	defer p.doSave()
+// h2cConflict reports whether server name, which is created if not exist,
+// shares a port with other servers, while it or any of them serves gRPC
+// without https. grpc tells if a gRPC mapping is being added to it. Caller
+// must hold the lock.
+func (p *Persistor) h2cConflict(name string, grpc bool) bool {
+	srv, ok := p.servers[name]
+	if !ok {
+		srv = NewServer(name)
+	}
 
-	if _, ok := p.servers[name]; !ok {
-		return
+	srv.RLock()
+	ports, h2c := srv.httpPorts(), srv.h2c() || (grpc && srv.TLS == nil)
+	srv.RUnlock()
+
+	for _, o := range p.servers {
+		if o == srv {
+			continue
+		}
+		o.RLock()
+		others, otherH2C := o.httpPorts(), o.h2c()
+		o.RUnlock()
+		if (h2c || otherH2C) && samePort(ports, others) {
+			return true
+		}
 	}
+	return false
+}
 
-	ret = p.getServer(name)
-	if !ret.Delete(path) {
+// samePort reports whether a and b have any port in common
+func samePort(a, b []int) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Delete a path-upstream mapping, ret is nil if there's no such mapping
+func (p *Persistor) Delete(name, path string) (ret *NginxServer, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	srv, ok := p.servers[name]
+	if !ok || !srv.Delete(path) {
 		return
 	}
 
-	if ret.Len() < 1 {
+	if srv.Len() < 1 {
 		delete(p.servers, name)
 	}
+	ret = srv
+	err = p.doSave()
 	return
 }
 
 // Enable a mapping
-func (p *Persistor) Enable(name, path string) (ret map[string]*NginxServer) {
+func (p *Persistor) Enable(name, path string) (ret map[string]*NginxServer, err error) {
 	p.Lock()
 	defer p.Unlock()
-	defer p.doSave()
-
-	if name == "" {
-		return p.enableAll()
-	}
 
-	var srv *NginxServer
-	if path == "" {
-		srv = p.enableServer(name)
-	} else {
-		srv = p.enableOne(name, path)
+	switch {
+	case name == "":
+		ret = p.enableAll()
+	case path == "":
+		srv := p.enableServer(name)
+		ret = map[string]*NginxServer{srv.ServerName: srv}
+	default:
+		srv := p.enableOne(name, path)
+		ret = map[string]*NginxServer{srv.ServerName: srv}
 	}
 
-	ret = map[string]*NginxServer{
-		srv.ServerName: srv,
-	}
+	err = p.doSave()
 	return
 }
 
@@ -210,25 +590,22 @@ func (p *Persistor) enableAll() (ret map[string]*NginxServer) {
 }
 
 // Disable a mapping
-func (p *Persistor) Disable(name, path string) (ret map[string]*NginxServer) {
+func (p *Persistor) Disable(name, path string) (ret map[string]*NginxServer, err error) {
 	p.Lock()
 	defer p.Unlock()
-	defer p.doSave()
-
-	if name == "" {
-		return p.disableAll()
-	}
 
-	var srv *NginxServer
-	if path == "" {
-		srv = p.disableServer(name)
-	} else {
-		srv = p.disableOne(name, path)
+	switch {
+	case name == "":
+		ret = p.disableAll()
+	case path == "":
+		srv := p.disableServer(name)
+		ret = map[string]*NginxServer{srv.ServerName: srv}
+	default:
+		srv := p.disableOne(name, path)
+		ret = map[string]*NginxServer{srv.ServerName: srv}
 	}
 
-	ret = map[string]*NginxServer{
-		srv.ServerName: srv,
-	}
+	err = p.doSave()
 	return
 }
 
@@ -255,6 +632,644 @@ func (p *Persistor) disableAll() (ret map[string]*NginxServer) {
 	return
 }
 
+// SetTLS sets https settings of a server, nil c disables https. It returns
+// nil if no such server.
+func (p *Persistor) SetTLS(name string, c *TLSConfig) (ret *NginxServer, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	ret, ok := p.servers[name]
+	if !ok {
+		return nil, nil
+	}
+
+	if c != nil && c.Source != TLSSourceFile {
+		issuer, ok := p.issuers[c.Source]
+		if !ok {
+			return nil, errors.New("no issuer for certificate source " + c.Source)
+		}
+		ret.RLock()
+		host := ret.primaryName()
+		ret.RUnlock()
+		if host == "" {
+			return nil, errors.New("cannot manage certificate without server name")
+		}
+		c.Certificate, c.Key = issuer.Paths(host)
+		if ch, ok := issuer.(challenger); ok {
+			c.Challenge = ch.ChallengeDir()
+		}
+	}
+
+	ret.RLock()
+	old := ret.TLS
+	ret.RUnlock()
+	if err = ret.SetTLS(c); err != nil {
+		return nil, err
+	}
+	if err = p.listenConflict(ret); err != nil {
+		ret.SetTLS(old)
+		return nil, err
+	}
+	if err = p.issueLocal(ret); err != nil {
+		ret.SetTLS(old)
+		return nil, err
+	}
+
+	err = p.doSave()
+	return
+}
+
+// RenewCertificates issues certificates managed by Yeast if missing or
+// expiring, and saves config if any is issued. Issuing may take a while, so
+// it is done without holding the lock.
+func (p *Persistor) RenewCertificates() (renewed bool, err error) {
+	p.Lock()
+	// keyed by names joined with space, which is not allowed in server name
+	todo := map[string]Issuer{}
+	for _, srv := range p.servers {
+		srv.RLock()
+		tls := srv.TLS
+		hosts := srv.certNames()
+		srv.RUnlock()
+		if tls == nil || tls.Source == TLSSourceFile {
+			continue
+		}
+
+		issuer, ok := p.issuers[tls.Source]
+		if ok && !issuer.Valid(hosts...) {
+			todo[strings.Join(hosts, " ")] = issuer
+		}
+	}
+	p.Unlock()
+
+	for hosts, issuer := range todo {
+		if _, _, e := issuer.Issue(strings.Fields(hosts)...); e != nil {
+			err = fmt.Errorf("cannot issue certificate of %s: %s", hosts, e)
+			continue
+		}
+		renewed = true
+	}
+
+	if renewed {
+		p.Lock()
+		defer p.Unlock()
+		if e := p.doSave(); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+// SavePool creates or replaces a pool
+func (p *Persistor) SavePool(pool *Pool) error {
+	p.Lock()
+	defer p.Unlock()
+
+	p.pools[pool.Name] = pool
+	return p.doSave()
+}
+
+// DeletePool deletes a pool, it fails if any mapping or override uses it
+func (p *Persistor) DeletePool(name string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.pools[name]; !ok {
+		return ErrNoSuchPool
+	}
+
+	for _, srv := range p.servers {
+		for _, mapping := range srv.List() {
+			if mapping.Pool == name {
+				return ErrPoolInUse
+			}
+			for _, o := range mapping.Overrides {
+				if o.Upstream == name {
+					return ErrPoolInUse
+				}
+			}
+		}
+	}
+
+	delete(p.pools, name)
+	return p.doSave()
+}
+
+// SetPoolMember marks a server in pool as down or up
+func (p *Persistor) SetPoolMember(name, address string, down bool) (ret *Pool, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	ret, ok := p.pools[name]
+	if !ok {
+		return nil, ErrNoSuchPool
+	}
+
+	member := ret.Member(address)
+	if member == nil {
+		return nil, ErrNoSuchMember
+	}
+
+	member.Down = down
+	err = p.doSave()
+	return
+}
+
+// HasPool reports whether pool exists
+func (p *Persistor) HasPool(name string) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	_, ok := p.pools[name]
+	return ok
+}
+
+// Pools lists all pools, sorted by name
+func (p *Persistor) Pools() []*Pool {
+	p.Lock()
+	defer p.Unlock()
+
+	ret := p.dataset().Pools
+	sort.Sort(poolsByName(ret))
+	return ret
+}
+
+// SetUser adds user to credential set name, or changes password if user
+// exists. The credential set is created if not exist.
+func (p *Persistor) SetUser(name, user, password string) (ret *CredentialSet, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	ret, ok := p.creds[name]
+	if !ok {
+		if ret, err = NewCredentialSet(name); err != nil {
+			return nil, err
+		}
+		ret.file = p.credentialsFile(name)
+	}
+	if err = ret.SetUser(user, password); err != nil {
+		return nil, err
+	}
+
+	p.creds[name] = ret
+	err = p.doSave()
+	return
+}
+
+// DeleteUser removes user from credential set name
+func (p *Persistor) DeleteUser(name, user string) (ret *CredentialSet, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	ret, ok := p.creds[name]
+	if !ok {
+		return nil, ErrNoSuchCredentials
+	}
+	if _, ok := ret.Users[user]; !ok {
+		return nil, ErrNoSuchUser
+	}
+
+	delete(ret.Users, user)
+	err = p.doSave()
+	return
+}
+
+// DeleteCredentials deletes a credential set and its htpasswd file, it fails
+// if any server or mapping uses it
+func (p *Persistor) DeleteCredentials(name string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	c, ok := p.creds[name]
+	if !ok {
+		return ErrNoSuchCredentials
+	}
+
+	for _, srv := range p.servers {
+		srv.RLock()
+		auth := srv.Auth
+		srv.RUnlock()
+		if auth == name {
+			return ErrCredentialsInUse
+		}
+		for _, mapping := range srv.List() {
+			if mapping.Auth == name {
+				return ErrCredentialsInUse
+			}
+		}
+	}
+
+	delete(p.creds, name)
+	if err := os.Remove(c.file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return p.doSave()
+}
+
+// HasCredentials reports whether credential set exists
+func (p *Persistor) HasCredentials(name string) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	_, ok := p.creds[name]
+	return ok
+}
+
+// Credentials lists all credential sets, sorted by name
+func (p *Persistor) Credentials() []*CredentialSet {
+	p.Lock()
+	defer p.Unlock()
+
+	ret := p.dataset().Credentials
+	sort.Sort(byCredentialsName(ret))
+	return ret
+}
+
+// SetAuth protects server name with credential set, or removes protection if
+// credentials is empty
+func (p *Persistor) SetAuth(name, credentials string) (ret *NginxServer, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	ret, ok := p.servers[name]
+	if !ok {
+		return nil, nil
+	}
+	if _, ok := p.creds[credentials]; credentials != "" && !ok {
+		return nil, ErrNoSuchCredentials
+	}
+
+	ret.SetAuth(credentials)
+	err = p.doSave()
+	return
+}
+
+// SaveAddressList creates or replaces an address list
+func (p *Persistor) SaveAddressList(l *AddressList) error {
+	p.Lock()
+	defer p.Unlock()
+
+	p.lists[l.Name] = l
+	return p.doSave()
+}
+
+// DeleteAddressList deletes an address list, it fails if any server or
+// mapping uses it
+func (p *Persistor) DeleteAddressList(name string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.lists[name]; !ok {
+		return ErrNoSuchList
+	}
+
+	uses := func(a *Access) bool {
+		if a == nil {
+			return false
+		}
+		for _, l := range a.lists() {
+			if l == name {
+				return true
+			}
+		}
+		return false
+	}
+	for _, srv := range p.servers {
+		srv.RLock()
+		access := srv.Access
+		srv.RUnlock()
+		if uses(access) {
+			return ErrListInUse
+		}
+		for _, mapping := range srv.List() {
+			if uses(mapping.Access) {
+				return ErrListInUse
+			}
+		}
+	}
+
+	delete(p.lists, name)
+	return p.doSave()
+}
+
+// AddressLists lists all address lists, sorted by name
+func (p *Persistor) AddressLists() []*AddressList {
+	p.Lock()
+	defer p.Unlock()
+
+	ret := p.dataset().AddressLists
+	sort.Sort(byListName(ret))
+	return ret
+}
+
+// SetAccess restricts client addresses of server name with a, or removes
+// restriction if a is nil
+func (p *Persistor) SetAccess(name string, a *Access) (ret *NginxServer, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	ret, ok := p.servers[name]
+	if !ok {
+		return nil, nil
+	}
+	if err = p.checkLists(a); err != nil {
+		return nil, err
+	}
+
+	ret.SetAccess(a)
+	err = p.doSave()
+	return
+}
+
+// checkLists returns ErrNoSuchList if a uses unknown address list, caller
+// must hold the lock
+func (p *Persistor) checkLists(a *Access) error {
+	if a == nil {
+		return nil
+	}
+	for _, l := range a.lists() {
+		if _, ok := p.lists[l]; !ok {
+			return ErrNoSuchList
+		}
+	}
+	return nil
+}
+
+// checkOverridePools returns ErrNoSuchPool if an override of m refers to
+// unknown pool, caller must hold the lock
+func (p *Persistor) checkOverridePools(m *Mapping) error {
+	for _, o := range m.Overrides {
+		if _, ok := p.pools[o.Upstream]; !ok && isPoolLike(o.Upstream) {
+			return ErrNoSuchPool
+		}
+	}
+	return nil
+}
+
+// PurgeCache removes cached responses of a mapping
+func (p *Persistor) PurgeCache(name, path string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	srv, ok := p.servers[name]
+	if !ok {
+		return ErrNoSuchMapping
+	}
+	mapping, ok := srv.List()[path]
+	if !ok {
+		return ErrNoSuchMapping
+	}
+	if mapping.Cache == nil {
+		return ErrNoCache
+	}
+
+	// keep the directory, it is managed by nginx
+	dir := filepath.Join(p.cacheDir(), cacheZone(locationID(name, path)))
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.RemoveAll(filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetOverride adds upstream override o to mapping at path of server name, or
+// replaces the one selected by same header or cookie value
+func (p *Persistor) SetOverride(name, path string, o *Override) (ret *NginxServer, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	ret, ok := p.servers[name]
+	if !ok {
+		return nil, ErrNoSuchMapping
+	}
+	if _, ok := p.pools[o.Upstream]; !ok && isPoolLike(o.Upstream) {
+		return nil, fmt.Errorf("no such pool %s", o.Upstream)
+	}
+	m, err := ret.SetOverride(path, o)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, ErrNoSuchMapping
+	}
+
+	m.UpdatedAt = time.Now()
+	err = p.doSave()
+	return
+}
+
+// DeleteOverride removes upstream override selected by header or cookie value
+// of o from mapping at path of server name
+func (p *Persistor) DeleteOverride(name, path string, o *Override) (ret *NginxServer, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	ret, ok := p.servers[name]
+	if !ok || ret.List()[path] == nil {
+		return nil, ErrNoSuchMapping
+	}
+	m := ret.DeleteOverride(path, o)
+	if m == nil {
+		return nil, ErrNoSuchOverride
+	}
+
+	m.UpdatedAt = time.Now()
+	err = p.doSave()
+	return
+}
+
+// SetTuning sets timeouts, buffering and body size of server name, or resets
+// them if t is nil
+func (p *Persistor) SetTuning(name string, t *Tuning) (ret *NginxServer, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	ret, ok := p.servers[name]
+	if !ok {
+		return nil, nil
+	}
+
+	ret.SetTuning(t)
+	err = p.doSave()
+	return
+}
+
+// SetListen sets server names and listen addresses of server. Paths of
+// certificate managed by Yeast follow the new server name.
+func (p *Persistor) SetListen(name string, names []string, listens []*Listen) (ret *NginxServer, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	ret, ok := p.servers[name]
+	if !ok {
+		return nil, nil
+	}
+
+	ret.RLock()
+	oldNames, oldListens := ret.Names, ret.Listens
+	ret.RUnlock()
+	if err = ret.SetListen(names, listens); err != nil {
+		return nil, err
+	}
+	if err = p.listenConflict(ret); err != nil {
+		ret.SetListen(oldNames, oldListens)
+		return nil, err
+	}
+
+	ret.Lock()
+	c := ret.TLS
+	var oldCert, oldKey string
+	if c != nil && c.Source != TLSSourceFile {
+		oldCert, oldKey = c.Certificate, c.Key
+		if issuer, ok := p.issuers[c.Source]; ok {
+			c.Certificate, c.Key = issuer.Paths(ret.primaryName())
+		}
+	}
+	ret.Unlock()
+
+	if err = p.issueLocal(ret); err != nil {
+		ret.Lock()
+		c.Certificate, c.Key = oldCert, oldKey
+		ret.Unlock()
+		ret.SetListen(oldNames, oldListens)
+		return nil, err
+	}
+
+	err = p.doSave()
+	return
+}
+
+// issueLocal issues certificate of srv at once if its issuer needs no
+// challenge, like local CA, so the https server is never left out of config.
+// Others are issued by RenewCertificates. Caller must hold the lock.
+func (p *Persistor) issueLocal(srv *NginxServer) error {
+	srv.RLock()
+	c, hosts := srv.TLS, srv.certNames()
+	srv.RUnlock()
+	if c == nil || c.Source == TLSSourceFile {
+		return nil
+	}
+
+	issuer, ok := p.issuers[c.Source]
+	if _, challenge := issuer.(challenger); !ok || challenge {
+		return nil
+	}
+	_, _, err := issuer.Issue(hosts...)
+	return err
+}
+
+// streamOn returns tcp stream listening on any of ports, caller must hold the
+// lock
+func (p *Persistor) streamOn(ports []int) *Stream {
+	for _, s := range p.streams {
+		if s.protocol() != StreamTCP {
+			continue
+		}
+		for _, port := range ports {
+			if s.Port == port {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+// listenConflict returns ErrPortInUse if srv listens on port of a tcp stream,
+// ErrDefaultServer if srv is default server of an address which another
+// server is default of, or ErrSharedH2C if srv or another server listening on
+// same port serves gRPC without https. Caller must hold the lock.
+func (p *Persistor) listenConflict(srv *NginxServer) error {
+	srv.RLock()
+	ports, listens, h2c := srv.httpPorts(), srv.listens(), srv.h2c()
+	srv.RUnlock()
+	if p.streamOn(ports) != nil {
+		return ErrPortInUse
+	}
+
+	for _, o := range p.servers {
+		if o == srv {
+			continue
+		}
+		o.RLock()
+		others, otherPorts, otherH2C := o.listens(), o.httpPorts(), o.h2c()
+		o.RUnlock()
+		if (h2c || otherH2C) && samePort(ports, otherPorts) {
+			return ErrSharedH2C
+		}
+		for _, l := range listens {
+			for _, x := range others {
+				if l.Default && x.Default && l.addr() == x.addr() {
+					return ErrDefaultServer
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// SaveStream creates or replaces a stream, it fails if the port is used by
+// other streams or servers
+func (p *Persistor) SaveStream(s *Stream) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.streamfile == "" {
+		return ErrNoStreamFile
+	}
+
+	for name, o := range p.streams {
+		if name != s.Name && s.conflicts(o) {
+			return ErrPortInUse
+		}
+	}
+	if s.protocol() == StreamTCP {
+		for _, srv := range p.servers {
+			srv.RLock()
+			ports := srv.httpPorts()
+			srv.RUnlock()
+			for _, port := range ports {
+				if port == s.Port {
+					return ErrPortInUse
+				}
+			}
+		}
+	}
+
+	p.streams[s.Name] = s
+	return p.doSave()
+}
+
+// DeleteStream deletes a stream
+func (p *Persistor) DeleteStream(name string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.streams[name]; !ok {
+		return ErrNoSuchStream
+	}
+
+	delete(p.streams, name)
+	return p.doSave()
+}
+
+// Streams lists all streams, sorted by port
+func (p *Persistor) Streams() []*Stream {
+	p.Lock()
+	defer p.Unlock()
+
+	ret := p.dataset().Streams
+	sort.Sort(streamsByPort(ret))
+	return ret
+}
+
 // List all server and mappings
 func (p *Persistor) List() (ret map[string]*NginxServer) {
 	p.Lock()
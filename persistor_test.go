@@ -6,33 +6,36 @@ package main
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"text/template"
 )
 
-// create persistor
+// create persistor, data files are put in a temp dir
 func cp(t *testing.T) (ret *Persistor) {
-	f, err := ioutil.TempFile("", "db")
+	dir, err := ioutil.TempDir("", "yeast")
 	if err != nil {
+		t.Fatalf("Cannot create dir when creating persistor: %s", err)
+	}
+
+	fn := filepath.Join(dir, "db")
+	if err := ioutil.WriteFile(fn, nil, 0644); err != nil {
 		t.Fatalf("Cannot create db when creating persistor: %s", err)
 	}
-	defer f.Close()
-	fn := f.Name()
 
-	f, err = ioutil.TempFile("", "nginx")
-	if err != nil {
+	nginx := filepath.Join(dir, "nginx")
+	if err := ioutil.WriteFile(nginx, nil, 0644); err != nil {
 		t.Fatalf("Cannot create conf when creating persistor: %s", err)
 	}
-	defer f.Close()
-	nginx := f.Name()
 
 	ret = NewPersistor(fn, nginx)
+	ret.SetCacheDir(filepath.Join(dir, "cache"))
 	return
 }
 
 // delete persistor
 func dp(p *Persistor) {
-	os.Remove(p.filename)
-	os.Remove(p.conffile)
+	os.RemoveAll(filepath.Dir(p.filename))
 }
 
 func TestListEmpty(t *testing.T) {
@@ -104,6 +107,25 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestExportFailure(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	if _, err := p.Create("test.server", "/test/", "http://upstream", ""); err != nil {
+		t.Fatalf("Cannot create mapping: %s", err)
+	}
+	before, _ := ioutil.ReadFile(p.conffile)
+
+	tmpl := template.Must(template.New("default").Parse(`{{define "config"}}partial{{.Nope}}{{end}}`))
+	p.SetRenderer(&Renderer{tmpl})
+	if _, err := p.Create("test.server", "/other/", "http://upstream", ""); err == nil {
+		t.Error("Error of rendering is not returned")
+	}
+	if after, _ := ioutil.ReadFile(p.conffile); string(after) != string(before) {
+		t.Errorf("Config is overwritten when rendering fails, got:\n%s", after)
+	}
+}
+
 func TestDisableOne(t *testing.T) {
 	p := cp(t)
 	defer dp(p)
@@ -204,3 +226,101 @@ func TestEnableAll(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateMetadata(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	p.CreateMapping("test.server", "/test/", &Mapping{
+		Upstream:    "http://upstream",
+		Description: "legacy billing",
+		Owner:       "billing team",
+		Labels:      map[string]string{"team": "billing"},
+	})
+
+	mapping := p.List()["test.server"].Paths["/test/"]
+	if mapping.Description != "legacy billing" || mapping.Owner != "billing team" {
+		t.Errorf("Metadata differs from just created one: %#v", mapping)
+	}
+	if mapping.Labels["team"] != "billing" {
+		t.Errorf("Labels differs from just created one: %#v", mapping.Labels)
+	}
+	if mapping.CreatedAt.IsZero() || !mapping.UpdatedAt.Equal(mapping.CreatedAt) {
+		t.Errorf("Timestamps not set when creating: %s, %s", mapping.CreatedAt, mapping.UpdatedAt)
+	}
+}
+
+func TestModifyMetadata(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	p.Create("test.server", "/test/", "http://upstream", "")
+	created := p.List()["test.server"].Paths["/test/"].CreatedAt
+	p.ModifyMapping("test.server", "/test/", "/orz/", &Mapping{
+		Upstream: "http://orz",
+		Owner:    "someone",
+	})
+
+	mapping := p.List()["test.server"].Paths["/orz/"]
+	if mapping.Owner != "someone" {
+		t.Errorf("Owner %s differs from modified one", mapping.Owner)
+	}
+	if !mapping.CreatedAt.Equal(created) {
+		t.Errorf("Creation time changed after modifying: %s => %s", created, mapping.CreatedAt)
+	}
+	if mapping.UpdatedAt.Before(created) {
+		t.Errorf("Update time %s is before creation time %s", mapping.UpdatedAt, created)
+	}
+}
+
+func TestLoadLegacy(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	legacy := `[{"name":"test.server","paths":{"/test/":{"upstream":"http://upstream","custom_tags":"","enabled":true}}}]`
+	if err := ioutil.WriteFile(p.filename, []byte(legacy), 0644); err != nil {
+		t.Fatalf("Cannot write legacy data: %s", err)
+	}
+
+	if err := p.Load(); err != nil {
+		t.Fatalf("Cannot load legacy data: %s", err)
+	}
+	if mapping, ok := p.List()["test.server"].Paths["/test/"]; !ok || mapping.Upstream != "http://upstream" {
+		t.Errorf("Legacy data is not loaded, got %#v", p.List())
+	}
+}
+
+func TestLoadTypedModifier(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	legacy := `{"servers":[{"name":"test.server","paths":{"= /health":{"upstream":"http://upstream","custom_tags":"","enabled":true}}}]}`
+	if err := ioutil.WriteFile(p.filename, []byte(legacy), 0644); err != nil {
+		t.Fatalf("Cannot write data: %s", err)
+	}
+
+	if err := p.Load(); err != nil {
+		t.Fatalf("Cannot load data: %s", err)
+	}
+	mapping, ok := p.List()["test.server"].Paths["= /health"]
+	if !ok || mapping.Match != MatchExact {
+		t.Errorf("Modifier in path is not converted, got %#v", p.List()["test.server"].Paths)
+	}
+}
+
+func TestLoadMatchKey(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	legacy := `{"servers":[{"name":"test.server","paths":{"/health":{"upstream":"http://upstream","match":"exact","custom_tags":"","enabled":true}}}]}`
+	if err := ioutil.WriteFile(p.filename, []byte(legacy), 0644); err != nil {
+		t.Fatalf("Cannot write data: %s", err)
+	}
+
+	if err := p.Load(); err != nil {
+		t.Fatalf("Cannot load data: %s", err)
+	}
+	if _, ok := p.List()["test.server"].Paths["= /health"]; !ok {
+		t.Errorf("Mapping is not keyed by match and path, got %#v", p.List()["test.server"].Paths)
+	}
+}
@@ -0,0 +1,134 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// load balancing methods of Pool
+const (
+	MethodRoundRobin = "round_robin"
+	MethodLeastConn  = "least_conn"
+	MethodIPHash     = "ip_hash"
+	MethodHash       = "hash"
+)
+
+var validMethods = map[string]bool{
+	"":               true,
+	MethodRoundRobin: true,
+	MethodLeastConn:  true,
+	MethodIPHash:     true,
+	MethodHash:       true,
+}
+
+// poolName matches valid pool names
+var poolName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Pool is a group of backends, AKA upstream segment in nginx
+type Pool struct {
+	Name   string `json:"name"`
+	Method string `json:"method,omitempty"`
+
+	// key of MethodHash, like "$request_uri"
+	HashKey    string `json:"hash_key,omitempty"`
+	Consistent bool   `json:"consistent,omitempty"`
+
+	Servers []*PoolServer `json:"servers"`
+
+	// idle connections to keep for each worker, disabled if 0
+	Keepalive         int    `json:"keepalive,omitempty"`
+	KeepaliveTimeout  string `json:"keepalive_timeout,omitempty"`
+	KeepaliveRequests int    `json:"keepalive_requests,omitempty"`
+}
+
+// PoolServer is a backend server in Pool
+type PoolServer struct {
+	Address string `json:"address"`
+	Weight  int    `json:"weight,omitempty"`
+
+	// passive health check, nginx defaults are used if omitted
+	MaxFails    *int   `json:"max_fails,omitempty"`
+	FailTimeout string `json:"fail_timeout,omitempty"`
+
+	Backup bool `json:"backup,omitempty"`
+	Down   bool `json:"down,omitempty"`
+}
+
+// Validate checks if p is malformed
+func (p *Pool) Validate() error {
+	if !poolName.MatchString(p.Name) {
+		return fmt.Errorf("invalid pool name %q", p.Name)
+	}
+
+	if !validMethods[p.Method] {
+		return fmt.Errorf("unknown load balancing method %s", p.Method)
+	}
+	if p.Method == MethodHash && p.HashKey == "" {
+		return fmt.Errorf("hash method needs a key")
+	}
+	if p.Method != MethodHash && (p.HashKey != "" || p.Consistent) {
+		return fmt.Errorf("hash key is only used by hash method")
+	}
+
+	if p.Keepalive < 0 || p.KeepaliveRequests < 0 {
+		return fmt.Errorf("keepalive settings should not be negative")
+	}
+	if p.KeepaliveTimeout != "" && !isTime(p.KeepaliveTimeout) {
+		return fmt.Errorf("invalid keepalive timeout %s", p.KeepaliveTimeout)
+	}
+	if p.Keepalive == 0 && (p.KeepaliveTimeout != "" || p.KeepaliveRequests != 0) {
+		return fmt.Errorf("keepalive timeout and requests need keepalive")
+	}
+
+	if len(p.Servers) == 0 {
+		return fmt.Errorf("pool %s has no server", p.Name)
+	}
+	seen := map[string]bool{}
+	for _, s := range p.Servers {
+		if s.Address == "" || strings.Contains(s.Address, "://") {
+			return fmt.Errorf("invalid server address %q, it should be host:port or unix:/path", s.Address)
+		}
+		if seen[s.Address] {
+			return fmt.Errorf("duplicated server address %s", s.Address)
+		}
+		seen[s.Address] = true
+
+		if s.Weight < 0 {
+			return fmt.Errorf("weight of %s should not be negative", s.Address)
+		}
+		if s.MaxFails != nil && *s.MaxFails < 0 {
+			return fmt.Errorf("max_fails of %s should not be negative", s.Address)
+		}
+		if s.FailTimeout != "" && !isTime(s.FailTimeout) {
+			return fmt.Errorf("invalid fail_timeout %s of %s", s.FailTimeout, s.Address)
+		}
+		if s.Backup && (p.Method == MethodHash || p.Method == MethodIPHash) {
+			return fmt.Errorf("backup server cannot be used with %s method", p.Method)
+		}
+	}
+
+	return nil
+}
+
+// poolPrefix is prepended to pool names in upstream segments, so pools do not
+// capture host names like "localhost" used by other mappings
+const poolPrefix = "yeast_"
+
+// UpstreamName returns name of upstream segment of p
+func (p *Pool) UpstreamName() string {
+	return poolPrefix + p.Name
+}
+
+// Member returns server with address, or nil if not found
+func (p *Pool) Member(address string) *PoolServer {
+	for _, s := range p.Servers {
+		if s.Address == address {
+			return s
+		}
+	}
+	return nil
+}
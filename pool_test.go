@@ -0,0 +1,234 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPoolValidate(t *testing.T) {
+	valid := []*Pool{
+		{Name: "orders", Servers: []*PoolServer{{Address: "10.0.0.1:8080"}}},
+		{Name: "orders", Method: MethodLeastConn, Servers: []*PoolServer{{Address: "unix:/tmp/a.sock", Weight: 2}}},
+		{Name: "orders", Method: MethodHash, HashKey: "$request_uri", Servers: []*PoolServer{{Address: "a:80"}}},
+	}
+	for _, p := range valid {
+		if err := p.Validate(); err != nil {
+			t.Errorf("Valid pool %#v is rejected: %s", p, err)
+		}
+	}
+
+	invalid := []*Pool{
+		{Name: "", Servers: []*PoolServer{{Address: "a:80"}}},
+		{Name: "a b", Servers: []*PoolServer{{Address: "a:80"}}},
+		{Name: "orders"},
+		{Name: "orders", Method: "random", Servers: []*PoolServer{{Address: "a:80"}}},
+		{Name: "orders", Method: MethodHash, Servers: []*PoolServer{{Address: "a:80"}}},
+		{Name: "orders", HashKey: "$uri", Servers: []*PoolServer{{Address: "a:80"}}},
+		{Name: "orders", Servers: []*PoolServer{{Address: "http://a:80"}}},
+		{Name: "orders", Servers: []*PoolServer{{Address: "a:80", Weight: -1}}},
+	}
+	for _, p := range invalid {
+		if p.Validate() == nil {
+			t.Errorf("Invalid pool %#v is accepted", p)
+		}
+	}
+}
+
+func TestPoolExport(t *testing.T) {
+	expect := `upstream yeast_orders {
+    least_conn;
+    server 10.0.0.1:8080 weight=3;
+    server 10.0.0.2:8080;
+}
+
+upstream yeast_search {
+    hash $request_uri consistent;
+    server 10.0.1.1:9200;
+}
+
+server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 80;
+
+    location /orders/ {
+        proxy_pass http://yeast_orders;
+        include proxy_params;
+        
+    }
+
+}
+`
+
+	s := NewServer("example.com")
+	s.CreateMapping("/orders/", &Mapping{Pool: "orders"})
+	data := &Dataset{
+		Servers: []*NginxServer{s},
+		Pools: []*Pool{
+			{Name: "search", Method: MethodHash, HashKey: "$request_uri", Consistent: true, Servers: []*PoolServer{{Address: "10.0.1.1:9200"}}},
+			{Name: "orders", Method: MethodLeastConn, Servers: []*PoolServer{
+				{Address: "10.0.0.1:8080", Weight: 3},
+				{Address: "10.0.0.2:8080"},
+			}},
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := defaultRenderer.Config(buf, data); err != nil {
+		t.Fatalf("Cannot render config: %s", err)
+	}
+	if actual := buf.String(); actual != expect {
+		t.Errorf("Pools returns %s", actual)
+	}
+}
+
+func TestPoolPersistor(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	pool := &Pool{Name: "orders", Servers: []*PoolServer{{Address: "10.0.0.1:8080"}}}
+	p.SavePool(pool)
+	p.CreateMapping("test.server", "/orders/", &Mapping{Pool: "orders"})
+
+	if err := p.DeletePool("orders"); err == nil {
+		t.Error("Deleting pool in use should be rejected")
+	}
+
+	loader := NewPersistor(p.filename, p.conffile)
+	if err := loader.Load(); err != nil {
+		t.Fatalf("Cannot load saved data: %s", err)
+	}
+	if actual := loader.Pools(); len(actual) != 1 || actual[0].Servers[0].Address != "10.0.0.1:8080" {
+		t.Errorf("Pools are not saved, got %#v", actual)
+	}
+
+	p.Delete("test.server", "/orders/")
+	if err := p.DeletePool("orders"); err != nil {
+		t.Errorf("Cannot delete pool: %s", err)
+	}
+	if len(p.Pools()) != 0 {
+		t.Error("Pool is not deleted")
+	}
+}
+
+func TestPoolHealthExport(t *testing.T) {
+	expect := `upstream yeast_orders {
+    server 10.0.0.1:8080 weight=2 max_fails=3 fail_timeout=30s;
+    server 10.0.0.2:8080 max_fails=0 down;
+    server 10.0.0.3:8080 backup;
+    keepalive 16;
+    keepalive_timeout 60s;
+}
+
+server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 80;
+
+    location /orders/ {
+        proxy_pass http://yeast_orders;
+        include proxy_params;
+        proxy_http_version 1.1;
+        proxy_set_header Connection "";
+        
+    }
+
+}
+`
+
+	three, zero := 3, 0
+	s := NewServer("example.com")
+	s.CreateMapping("/orders/", &Mapping{Pool: "orders"})
+	data := &Dataset{
+		Servers: []*NginxServer{s},
+		Pools: []*Pool{{
+			Name: "orders",
+			Servers: []*PoolServer{
+				{Address: "10.0.0.1:8080", Weight: 2, MaxFails: &three, FailTimeout: "30s"},
+				{Address: "10.0.0.2:8080", MaxFails: &zero, Down: true},
+				{Address: "10.0.0.3:8080", Backup: true},
+			},
+			Keepalive:        16,
+			KeepaliveTimeout: "60s",
+		}},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := defaultRenderer.Config(buf, data); err != nil {
+		t.Fatalf("Cannot render config: %s", err)
+	}
+	if actual := buf.String(); actual != expect {
+		t.Errorf("Pool health settings returns %s", actual)
+	}
+}
+
+func TestPoolHealthValidate(t *testing.T) {
+	invalid := []*Pool{
+		{Name: "a", Servers: []*PoolServer{{Address: "a:80", FailTimeout: "soon"}}},
+		{Name: "a", Method: MethodIPHash, Servers: []*PoolServer{{Address: "a:80", Backup: true}}},
+		{Name: "a", Servers: []*PoolServer{{Address: "a:80"}, {Address: "a:80"}}},
+		{Name: "a", KeepaliveTimeout: "60s", Servers: []*PoolServer{{Address: "a:80"}}},
+		{Name: "a", Keepalive: -1, Servers: []*PoolServer{{Address: "a:80"}}},
+	}
+	for _, p := range invalid {
+		if p.Validate() == nil {
+			t.Errorf("Invalid pool %#v is accepted", p)
+		}
+	}
+}
+
+func TestPoolMemberDown(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	p.SavePool(&Pool{Name: "orders", Servers: []*PoolServer{{Address: "10.0.0.1:8080"}, {Address: "10.0.0.2:8080"}}})
+	p.CreateMapping("test.server", "/orders/", &Mapping{Pool: "orders"})
+	mapping := p.List()["test.server"].Paths["/orders/"]
+	updated := mapping.UpdatedAt
+
+	if _, err := p.SetPoolMember("orders", "10.0.0.3:8080", true); err != ErrNoSuchMember {
+		t.Errorf("Expect ErrNoSuchMember, got %v", err)
+	}
+	if _, err := p.SetPoolMember("nope", "10.0.0.1:8080", true); err != ErrNoSuchPool {
+		t.Errorf("Expect ErrNoSuchPool, got %v", err)
+	}
+
+	pool, err := p.SetPoolMember("orders", "10.0.0.2:8080", true)
+	if err != nil {
+		t.Fatalf("Cannot mark server down: %s", err)
+	}
+	if pool.Servers[0].Down || !pool.Servers[1].Down {
+		t.Errorf("Wrong server is marked down: %#v, %#v", pool.Servers[0], pool.Servers[1])
+	}
+	if actual := p.List()["test.server"].Paths["/orders/"]; actual != mapping || !actual.UpdatedAt.Equal(updated) {
+		t.Error("Marking server down should not touch mappings")
+	}
+}
+
+func TestPoolNameNotCapturingHost(t *testing.T) {
+	s := NewServer("example.com")
+	s.Create("/local/", "http://localhost:8080", "")
+	s.CreateMapping("/pool/", &Mapping{Pool: "localhost"})
+	data := &Dataset{
+		Servers: []*NginxServer{s},
+		Pools:   []*Pool{{Name: "localhost", Servers: []*PoolServer{{Address: "10.0.0.1:8080"}}}},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := defaultRenderer.Config(buf, data); err != nil {
+		t.Fatalf("Cannot render config: %s", err)
+	}
+	actual := buf.String()
+	if strings.Contains(actual, "upstream localhost {") {
+		t.Errorf("Pool named localhost captures host localhost:\n%s", actual)
+	}
+	for _, expect := range []string{"proxy_pass http://localhost:8080;", "proxy_pass http://yeast_localhost;"} {
+		if !strings.Contains(actual, expect) {
+			t.Errorf("Config does not contain %q:\n%s", expect, actual)
+		}
+	}
+}
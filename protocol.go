@@ -0,0 +1,155 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// protocols to speak with upstream
+const (
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+	// ProtocolGRPC passes requests with grpc_pass, server listens with http2
+	ProtocolGRPC  = "grpc"
+	ProtocolGRPCS = "grpcs"
+	// ProtocolFastCGI passes requests to FastCGI server like PHP-FPM
+	ProtocolFastCGI = "fastcgi"
+	// ProtocolUWSGI passes requests to uwsgi server
+	ProtocolUWSGI = "uwsgi"
+)
+
+// protocolModules maps protocol to prefix of nginx directives, like
+// proxy_pass and grpc_pass
+var protocolModules = map[string]string{
+	ProtocolHTTP:  "proxy",
+	ProtocolHTTPS: "proxy",
+	ProtocolGRPC:  "grpc",
+	ProtocolGRPCS: "grpc",
+
+	ProtocolFastCGI: "fastcgi",
+	ProtocolUWSGI:   "uwsgi",
+}
+
+// splitScheme splits scheme from upstream, scheme is empty if not specified
+func splitScheme(upstream string) (scheme, rest string) {
+	idx := strings.Index(upstream, "://")
+	if idx < 0 {
+		return "", upstream
+	}
+	return upstream[:idx], upstream[idx+3:]
+}
+
+// protocol returns protocol of m, derived from scheme of upstream if not set
+func (m *Mapping) protocol() string {
+	if m.Protocol != "" {
+		return m.Protocol
+	}
+	if scheme, _ := splitScheme(m.Upstream); scheme == ProtocolHTTPS {
+		return ProtocolHTTPS
+	}
+	return ProtocolHTTP
+}
+
+// module returns prefix of nginx directives used to pass requests
+func (m *Mapping) module() string {
+	return protocolModules[m.protocol()]
+}
+
+// h2c reports whether s has any gRPC mapping without https, so it listens
+// with cleartext http2. Caller must hold lock of s.
+func (s *NginxServer) h2c() bool {
+	if s.TLS != nil {
+		return false
+	}
+	for _, m := range s.Paths {
+		if m.module() == "grpc" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateProtocol checks if settings of m can be used with its protocol
+func (m *Mapping) validateProtocol() error {
+	module, ok := protocolModules[m.protocol()]
+	if !ok {
+		return fmt.Errorf("unknown protocol %s", m.Protocol)
+	}
+
+	if m.ScriptRoot != "" && module != "fastcgi" {
+		return fmt.Errorf("script root is only used by %s", ProtocolFastCGI)
+	}
+	if module == "fastcgi" || module == "uwsgi" {
+		return m.validateGateway()
+	}
+
+	if scheme, _ := splitScheme(m.Upstream); scheme != "" && scheme != m.protocol() {
+		return fmt.Errorf("upstream %s does not speak %s", m.Upstream, m.protocol())
+	}
+	if module == "proxy" {
+		return nil
+	}
+
+	if _, uri := splitUpstream(m.pass()); uri != "" {
+		return fmt.Errorf("%s upstream cannot contain uri", m.protocol())
+	}
+	if m.Rewrite != nil || m.WebSocket || m.Streaming || m.Cache != nil {
+		return fmt.Errorf("rewrite, websocket, streaming and cache cannot be used with %s", m.protocol())
+	}
+	if t := m.Tuning; t != nil && (t.Buffering != nil || t.RequestBuffering != nil) {
+		return fmt.Errorf("buffering cannot be used with %s", m.protocol())
+	}
+	return nil
+}
+
+// validateGateway checks settings of fastcgi and uwsgi mappings
+func (m *Mapping) validateGateway() error {
+	if m.Upstream != "" && !isSocketAddress(m.Upstream) {
+		return fmt.Errorf("invalid %s upstream %q, it should be host:port or unix:/path", m.protocol(), m.Upstream)
+	}
+	if m.Rewrite != nil || m.WebSocket || m.Streaming || m.Cache != nil {
+		return fmt.Errorf("rewrite, websocket, streaming and cache cannot be used with %s", m.protocol())
+	}
+	if m.Headers != nil && len(m.Headers.Set) > 0 {
+		return fmt.Errorf("request headers cannot be set with %s", m.protocol())
+	}
+
+	if m.ScriptRoot != "" {
+		if !filepath.IsAbs(m.ScriptRoot) || strings.ContainsAny(m.ScriptRoot, " \t\n;{}\"'$") {
+			return fmt.Errorf("invalid script root %q", m.ScriptRoot)
+		}
+	}
+	return nil
+}
+
+// scriptFilename returns value of SCRIPT_FILENAME passed to fastcgi server
+func (m *Mapping) scriptFilename() string {
+	if m.ScriptRoot == "" {
+		return "$document_root$fastcgi_script_name"
+	}
+	return strings.TrimSuffix(m.ScriptRoot, "/") + "$fastcgi_script_name"
+}
+
+// pass returns argument of pass directive, like "http://127.0.0.1:8080/api",
+// fastcgi and uwsgi servers are passed without scheme
+func (m *Mapping) pass() string {
+	if m.Pool != "" {
+		return m.passOf(poolPrefix + m.Pool)
+	}
+	return m.passOf(m.Upstream)
+}
+
+// passOf returns argument of pass directive passing to target with protocol
+// of m
+func (m *Mapping) passOf(target string) string {
+	_, target = splitScheme(target)
+	switch m.module() {
+	case "fastcgi", "uwsgi":
+		return target
+	}
+	return m.protocol() + "://" + target
+}
@@ -0,0 +1,200 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProtocolValidate(t *testing.T) {
+	off := false
+	valid := []*Mapping{
+		{Upstream: "http://a"},
+		{Protocol: ProtocolHTTPS, Upstream: "a:8443"},
+		{Protocol: ProtocolHTTPS, Upstream: "https://a/api/"},
+		{Protocol: ProtocolGRPC, Upstream: "127.0.0.1:50051"},
+		{Protocol: ProtocolGRPCS, Upstream: "grpcs://a:443", ReadTimeout: "1h"},
+		{Protocol: ProtocolGRPC, Pool: "orders"},
+		{Protocol: ProtocolFastCGI, Upstream: "127.0.0.1:9000", ScriptRoot: "/var/www/html"},
+		{Protocol: ProtocolFastCGI, Pool: "php", Tuning: &Tuning{Buffering: &off}},
+		{Protocol: ProtocolUWSGI, Upstream: "unix:/run/uwsgi/app.sock"},
+	}
+	for _, m := range valid {
+		if err := m.Validate("/"); err != nil {
+			t.Errorf("Valid protocol %#v is rejected: %s", m, err)
+		}
+	}
+
+	invalid := []*Mapping{
+		{Protocol: "ftp", Upstream: "a"},
+		{Protocol: ProtocolGRPC, Upstream: "http://a"},
+		{Protocol: ProtocolHTTP, Upstream: "https://a"},
+		{Protocol: ProtocolGRPC, Upstream: "grpc://a/api"},
+		{Protocol: ProtocolGRPC, Upstream: "a", WebSocket: true},
+		{Protocol: ProtocolGRPC, Upstream: "a", Rewrite: &Rewrite{Mode: RewriteStrip}},
+		{Protocol: ProtocolGRPC, Upstream: "a", Cache: &Cache{Enabled: true}},
+		{Protocol: ProtocolGRPC, Upstream: "a", Tuning: &Tuning{Buffering: &off}},
+		{Type: MappingResponse, Protocol: ProtocolGRPC, Response: &Response{Status: 204}},
+		{Protocol: ProtocolFastCGI, Upstream: "http://127.0.0.1:9000"},
+		{Protocol: ProtocolFastCGI, Upstream: "php"},
+		{Protocol: ProtocolUWSGI, Upstream: "unix:run/app.sock"},
+		{Protocol: ProtocolFastCGI, Upstream: "php:9000", ScriptRoot: "www"},
+		{Protocol: ProtocolUWSGI, Upstream: "app:3031", ScriptRoot: "/srv/app"},
+		{Protocol: ProtocolFastCGI, Upstream: "php:9000", Streaming: true},
+		{Protocol: ProtocolUWSGI, Upstream: "app:3031", Headers: &HeaderRules{Set: []*Header{{Name: "X-A", Value: "a"}}}},
+	}
+	for _, m := range invalid {
+		if m.Validate("/svc/") == nil {
+			t.Errorf("Invalid protocol %#v is accepted", m)
+		}
+	}
+}
+
+func TestProtocolPass(t *testing.T) {
+	cases := []struct {
+		mapping *Mapping
+		expect  string
+	}{
+		{&Mapping{Upstream: "http://a/api/"}, "http://a/api/"},
+		{&Mapping{Upstream: "https://a"}, "https://a"},
+		{&Mapping{Pool: "orders"}, "http://yeast_orders"},
+		{&Mapping{Protocol: ProtocolHTTPS, Pool: "orders"}, "https://yeast_orders"},
+		{&Mapping{Protocol: ProtocolGRPC, Upstream: "127.0.0.1:50051"}, "grpc://127.0.0.1:50051"},
+		{&Mapping{Protocol: ProtocolGRPCS, Upstream: "grpcs://a:443"}, "grpcs://a:443"},
+		{&Mapping{Protocol: ProtocolFastCGI, Upstream: "127.0.0.1:9000"}, "127.0.0.1:9000"},
+		{&Mapping{Protocol: ProtocolUWSGI, Upstream: "unix:/run/app.sock"}, "unix:/run/app.sock"},
+		{&Mapping{Protocol: ProtocolFastCGI, Pool: "php"}, "yeast_php"},
+	}
+	for _, c := range cases {
+		if actual := c.mapping.pass(); actual != c.expect {
+			t.Errorf("pass of %#v: expect %s, got %s", c.mapping, c.expect, actual)
+		}
+	}
+}
+
+func TestProtocolExport(t *testing.T) {
+	expect := `server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 443 ssl http2;
+    ssl_certificate /etc/ssl/a.crt;
+    ssl_certificate_key /etc/ssl/a.key;
+    proxy_connect_timeout 3s;
+    proxy_read_timeout 30s;
+    proxy_send_timeout 10s;
+    proxy_buffering off;
+
+    location /orders.Orders/ {
+        grpc_pass grpc://127.0.0.1:50051;
+        grpc_set_header Host $host;
+        grpc_set_header X-Real-IP $remote_addr;
+        grpc_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        grpc_set_header X-Forwarded-Proto $scheme;
+        grpc_read_timeout 1h;
+        grpc_connect_timeout 5s;
+        grpc_send_timeout 10s;
+        grpc_set_header X-Team "orders";
+        
+    }
+
+    location /web/ {
+        proxy_pass https://web;
+        include proxy_params;
+        proxy_ssl_server_name on;
+        
+    }
+
+}`
+
+	s := NewServer("example.com")
+	s.CreateMapping("/orders.Orders/", &Mapping{
+		Protocol:    ProtocolGRPC,
+		Upstream:    "127.0.0.1:50051",
+		ReadTimeout: "1h",
+		Tuning:      &Tuning{ConnectTimeout: "5s"},
+		Headers:     &HeaderRules{Set: []*Header{{Name: "X-Team", Value: "orders"}}},
+	})
+	s.CreateMapping("/web/", &Mapping{Protocol: ProtocolHTTPS, Upstream: "web"})
+	s.SetTLS(&TLSConfig{Certificate: "/etc/ssl/a.crt", Key: "/etc/ssl/a.key"})
+	buffering := false
+	s.SetTuning(&Tuning{ConnectTimeout: "3s", ReadTimeout: "30s", SendTimeout: "10s", Buffering: &buffering})
+	if actual := s.Export(); actual != expect {
+		t.Errorf("Protocol returns %s", actual)
+	}
+
+	s.SetTLS(nil)
+	if actual := s.Export(); !strings.Contains(actual, "    listen 80 http2;\n") {
+		t.Errorf("gRPC server without https does not listen with http2, got:\n%s", actual)
+	}
+}
+
+func TestProtocolPersistor(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	grpc := &Mapping{Protocol: ProtocolGRPC, Upstream: "127.0.0.1:50051"}
+	if srv, err := p.CreateMapping("example.com", "/orders.Orders/", grpc); srv == nil || err != nil {
+		t.Fatalf("Cannot create gRPC mapping without https: %v", err)
+	}
+	if _, err := p.Create("web.example.com", "/", "http://127.0.0.1", ""); err != ErrSharedH2C {
+		t.Errorf("Server on port of cleartext gRPC server should be rejected, got %v", err)
+	}
+
+	p.Create("web.example.com:8080", "/", "http://127.0.0.1", "")
+	if _, err := p.SetListen("web.example.com:8080", nil, []*Listen{{Port: 80}}); err != ErrSharedH2C {
+		t.Errorf("Listening on port of cleartext gRPC server should be rejected, got %v", err)
+	}
+	if _, err := p.ModifyMapping("web.example.com:8080", "/", "/", grpc); err != nil {
+		t.Errorf("Cannot add gRPC mapping to server on its own port: %s", err)
+	}
+
+	p.SetTLS("example.com", &TLSConfig{Certificate: "/etc/ssl/a.crt", Key: "/etc/ssl/a.key"})
+	if _, err := p.Create("web.example.com", "/", "http://127.0.0.1", ""); err != nil {
+		t.Errorf("Cannot create server on port 80 of gRPC server moved to https: %s", err)
+	}
+	if _, err := p.SetTLS("example.com", nil); err != ErrSharedH2C {
+		t.Errorf("Disabling https of gRPC server on shared port should be rejected, got %v", err)
+	}
+}
+
+func TestProtocolGatewayExport(t *testing.T) {
+	expect := `server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 80;
+
+    location /app/ {
+        uwsgi_pass unix:/run/uwsgi/app.sock;
+        include uwsgi_params;
+        uwsgi_read_timeout 60s;
+        
+    }
+
+    location ~ \.php$ {
+        fastcgi_pass 127.0.0.1:9000;
+        include fastcgi_params;
+        fastcgi_param SCRIPT_FILENAME /var/www/html$fastcgi_script_name;
+        fastcgi_buffering off;
+        fastcgi_hide_header X-Powered-By;
+        
+    }
+
+}`
+
+	off := false
+	s := NewServer("example.com")
+	s.CreateMapping(`\.php$`, &Mapping{
+		Match:      MatchRegex,
+		Protocol:   ProtocolFastCGI,
+		Upstream:   "127.0.0.1:9000",
+		ScriptRoot: "/var/www/html/",
+		Tuning:     &Tuning{Buffering: &off},
+		Headers:    &HeaderRules{Hide: []string{"X-Powered-By"}},
+	})
+	s.CreateMapping("/app/", &Mapping{Protocol: ProtocolUWSGI, Upstream: "unix:/run/uwsgi/app.sock", ReadTimeout: "60s"})
+	if actual := s.Export(); actual != expect {
+		t.Errorf("Gateway protocols return %s", actual)
+	}
+}
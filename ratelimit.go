@@ -0,0 +1,91 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// keys of RateLimit
+const (
+	// RateLimitIP limits requests per client address
+	RateLimitIP = "ip"
+	// RateLimitHeader limits requests per value of request header
+	RateLimitHeader = "header"
+)
+
+// rateLimitZoneSize is size of shared memory zone of each RateLimit
+const rateLimitZoneSize = "10m"
+
+// RateLimit limits request rate of a mapping
+type RateLimit struct {
+	// requests allowed in a second, or in a minute if PerMinute is set
+	Rate      int  `json:"rate"`
+	PerMinute bool `json:"per_minute,omitempty"`
+	// excessive requests delayed instead of rejected
+	Burst int `json:"burst,omitempty"`
+	// serve burst requests without delay
+	NoDelay bool `json:"nodelay,omitempty"`
+
+	// how requests are counted, one of RateLimit* constants, ip if empty
+	Key string `json:"key,omitempty"`
+	// name of request header used with header key
+	Header string `json:"header,omitempty"`
+}
+
+// Validate checks if l is malformed
+func (l *RateLimit) Validate() error {
+	if l.Rate <= 0 {
+		return fmt.Errorf("rate should be positive")
+	}
+	if l.Burst < 0 {
+		return fmt.Errorf("burst cannot be negative")
+	}
+
+	switch l.Key {
+	case "", RateLimitIP:
+		if l.Header != "" {
+			return fmt.Errorf("header can be used only with header key")
+		}
+	case RateLimitHeader:
+		if !headerName.MatchString(l.Header) {
+			return fmt.Errorf("invalid header name %q", l.Header)
+		}
+	default:
+		return fmt.Errorf("unknown rate limit key %s", l.Key)
+	}
+	return nil
+}
+
+// rateLimitRule is rendered form of RateLimit
+type rateLimitRule struct {
+	Zone string
+	Size string
+	// nginx variable counted by
+	Key string
+	// like "10r/s"
+	Rate    string
+	Burst   int
+	NoDelay bool
+}
+
+// rule renders l, id identifies the location
+func (l *RateLimit) rule(id string) *rateLimitRule {
+	ret := &rateLimitRule{
+		Zone:    "rate_" + id,
+		Size:    rateLimitZoneSize,
+		Key:     "$binary_remote_addr",
+		Rate:    strconv.Itoa(l.Rate) + "r/s",
+		Burst:   l.Burst,
+		NoDelay: l.NoDelay,
+	}
+	if l.PerMinute {
+		ret.Rate = strconv.Itoa(l.Rate) + "r/m"
+	}
+	if l.Key == RateLimitHeader {
+		ret.Key = headerVariable(l.Header)
+	}
+	return ret
+}
@@ -0,0 +1,73 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRateLimitValidate(t *testing.T) {
+	valid := []*RateLimit{
+		{Rate: 10},
+		{Rate: 30, PerMinute: true, Burst: 5, NoDelay: true, Key: RateLimitIP},
+		{Rate: 1, Key: RateLimitHeader, Header: "X-Api-Key"},
+	}
+	for _, l := range valid {
+		if err := l.Validate(); err != nil {
+			t.Errorf("Valid rate limit %#v is rejected: %s", l, err)
+		}
+	}
+
+	invalid := []*RateLimit{
+		{},
+		{Rate: -1},
+		{Rate: 1, Burst: -1},
+		{Rate: 1, Key: "cookie"},
+		{Rate: 1, Key: RateLimitHeader},
+		{Rate: 1, Key: RateLimitHeader, Header: "X Key"},
+		{Rate: 1, Header: "X-Api-Key"},
+	}
+	for _, l := range invalid {
+		if l.Validate() == nil {
+			t.Errorf("Invalid rate limit %#v is accepted", l)
+		}
+	}
+}
+
+func TestRateLimitConfig(t *testing.T) {
+	s := NewServer("example.com")
+	s.CreateMapping("/api/", &Mapping{
+		Upstream:  "http://api",
+		RateLimit: &RateLimit{Rate: 10, Burst: 20, NoDelay: true},
+	})
+	s.CreateMapping("/search/", &Mapping{
+		Upstream:  "http://search",
+		RateLimit: &RateLimit{Rate: 30, PerMinute: true, Key: RateLimitHeader, Header: "X-Api-Key"},
+	})
+	api := "rate_" + locationID("example.com", "/api/")
+	search := "rate_" + locationID("example.com", "/search/")
+
+	buf := &bytes.Buffer{}
+	if err := defaultRenderer.Config(buf, &Dataset{Servers: []*NginxServer{s}}); err != nil {
+		t.Fatalf("Cannot render config: %s", err)
+	}
+	actual := buf.String()
+
+	zones := "limit_req_zone $binary_remote_addr zone=" + api + ":10m rate=10r/s;\n" +
+		"limit_req_zone $http_x_api_key zone=" + search + ":10m rate=30r/m;\n\n"
+	if !strings.HasPrefix(actual, zones) {
+		t.Errorf("Zones are not defined, got:\n%s", actual)
+	}
+
+	for _, expect := range []string{
+		"    location /api/ {\n        limit_req zone=" + api + " burst=20 nodelay;\n        limit_req_status 429;\n",
+		"    location /search/ {\n        limit_req zone=" + search + ";\n        limit_req_status 429;\n",
+	} {
+		if !strings.Contains(actual, expect) {
+			t.Errorf("Config does not contain %q:\n%s", expect, actual)
+		}
+	}
+}
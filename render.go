@@ -0,0 +1,804 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// defaultTemplate defines templates used to render nginx config:
+//
+//   - "config" renders whole config file with a configView
+//   - "server" renders a server segment with a serverView
+//   - "upstream" renders a pool, including implicit ones of overrides
+//   - "cors" renders origin maps of CORS
+//   - "override" renders maps of upstream overrides
+//   - "limit" renders zones of rate limits
+//   - "cache" renders cache zones
+//   - "streams" renders stream config file with Streams sorted by port, each
+//     by "stream"
+//
+// Others are helpers and can be redefined as well.
+const defaultTemplate = `{{define "config"}}{{if .Upgrade}}{{template "upgrade"}}
+{{end}}{{range .CORS}}{{template "cors" .}}
+{{end}}{{range .Overrides}}{{template "override" .}}
+{{end}}{{with .RateLimits}}{{range .}}{{template "limit" .}}{{end}}
+{{end}}{{with .Caches}}{{range .}}{{template "cache" .}}{{end}}
+{{end}}{{range .Pools}}{{template "upstream" .}}
+{{end}}{{range .Servers}}{{template "server" .}}
+{{end}}{{end}}
+
+{{- define "upgrade"}}map $http_upgrade $connection_upgrade {
+    default upgrade;
+    '' close;
+}
+{{end}}
+
+{{- define "cors"}}map $http_origin {{.Variable}} {
+    default "";
+{{- range .Origins}}
+    {{quote .}} $http_origin;
+{{- end}}
+}
+{{end}}
+
+{{- define "override"}}map {{.Source}} {{.Variable}} {
+    default {{.Default}};
+{{- range .Entries}}
+    {{quote .Value}} {{.Pass}};
+{{- end}}
+}
+{{end}}
+
+{{- define "limit"}}limit_req_zone {{.Key}} zone={{.Zone}}:{{.Size}} rate={{.Rate}};
+{{end}}
+
+{{- define "cache"}}proxy_cache_path {{.Path}} levels=1:2 keys_zone={{.Zone}}:{{.Size}} use_temp_path=off;
+{{end}}
+
+{{- define "upstream"}}upstream {{.UpstreamName}} {
+{{- if and .Method (ne .Method "round_robin")}}
+    {{.Method}}{{if .HashKey}} {{.HashKey}}{{end}}{{if .Consistent}} consistent{{end}};
+{{- end}}
+{{- range .Servers}}
+    server {{.Address}}
+        {{- if .Weight}} weight={{.Weight}}{{end}}
+        {{- with .MaxFails}} max_fails={{.}}{{end}}
+        {{- with .FailTimeout}} fail_timeout={{.}}{{end}}
+        {{- if .Backup}} backup{{end}}
+        {{- if .Down}} down{{end}};
+{{- end}}
+{{- with .Keepalive}}
+    keepalive {{.}};
+{{- end}}
+{{- with .KeepaliveTimeout}}
+    keepalive_timeout {{.}};
+{{- end}}
+{{- with .KeepaliveRequests}}
+    keepalive_requests {{.}};
+{{- end}}
+}
+{{end}}
+
+{{- define "server"}}{{if or .Redirect .Challenge}}{{template "http" .}}{{if not .Pending}}
+{{end}}{{end}}{{if not .Pending}}server {
+    client_max_body_size {{.MaxBodySize}};
+{{- with .Names}}
+    server_name {{join . " "}};
+{{- end}}
+{{- range .Listens}}
+    listen {{.Addr}}{{if $.TLS}} ssl http2{{else if $.HTTP2}} http2{{end}}{{if .Default}} default_server{{end}};
+{{- end}}
+{{- with .TLS}}
+    ssl_certificate {{.Certificate}};
+    ssl_certificate_key {{.Key}};
+{{- if .Protocols}}
+    ssl_protocols {{join .Protocols " "}};
+{{- end}}
+{{- end}}
+{{- if .Auth}}{{if .AuthFile}}
+    auth_basic {{quote .Auth}};
+    auth_basic_user_file {{.AuthFile}};
+{{- else}}
+    deny all;
+{{- end}}{{end}}
+{{- range .ACL}}
+    {{.Action}} {{.Source}};
+{{- end}}
+{{- range .TuningDirectives}}
+    {{.}};
+{{- end}}
+{{range .Locations}}
+{{template "location" .}}
+{{end}}
+}{{end}}{{end}}
+
+{{- define "http"}}server {
+{{- with .Names}}
+    server_name {{join . " "}};
+{{- end}}
+{{- range .HTTPListens}}
+    listen {{.Addr}}{{if .Default}} default_server{{end}};
+{{- end}}
+{{- if .Challenge}}
+
+    location ^~ /.well-known/acme-challenge/ {
+        default_type text/plain;
+        alias {{.Challenge}}/;
+    }
+{{- if .Redirect}}
+
+    location / {
+        return 301 {{.Redirect}};
+    }
+{{- end}}
+
+{{- else}}
+    return 301 {{.Redirect}};
+{{- end}}
+}
+{{end}}
+
+{{- define "location"}}    location {{.Args}} {
+{{- with .CORSRule}}
+        if ($request_method = OPTIONS) {
+            add_header Access-Control-Allow-Origin {{.Origin}} always;
+            add_header Access-Control-Allow-Methods {{quote .Methods}} always;
+            add_header Access-Control-Allow-Headers {{quote .Headers}} always;
+{{- if .Credentials}}
+            add_header Access-Control-Allow-Credentials true always;
+{{- end}}
+{{- with .MaxAge}}
+            add_header Access-Control-Max-Age {{.}} always;
+{{- end}}
+{{- if .Vary}}
+            add_header Vary Origin always;
+{{- end}}
+            return 204;
+        }
+{{- template "cors_headers" .}}
+{{- end}}
+{{- if eq .Auth "off"}}
+        auth_basic off;
+{{- else if .Auth}}{{if .AuthFile}}
+        auth_basic {{quote .Auth}};
+        auth_basic_user_file {{.AuthFile}};
+{{- else}}
+        deny all;
+{{- end}}{{end}}
+{{- range .ACL}}
+        {{.Action}} {{.Source}};
+{{- end}}
+{{- with .RateLimitRule}}
+        limit_req zone={{.Zone}}{{with .Burst}} burst={{.}}{{end}}{{if .NoDelay}} nodelay{{end}};
+        limit_req_status 429;
+{{- end}}
+{{- if .Return}}
+{{- with .Checked}}
+        try_files /dev/null {{.}};
+    }
+
+    location {{.}} {
+        auth_basic off;
+        allow all;
+{{- with $.CORSRule}}{{template "cors_headers" .}}{{end}}
+{{- end}}
+{{- with .Response}}{{with .ContentType}}
+        default_type {{quote .}};
+{{- end}}{{end}}
+        return {{.Return}};
+{{- else if .Static}}
+        {{if .Static.Alias}}alias{{else}}root{{end}} {{.Root}};
+{{- with .Static.Index}}
+        index {{.}};
+{{- end}}
+{{- if .Static.AutoIndex}}
+        autoindex on;
+{{- end}}
+{{- with .TryFiles}}
+        try_files {{.}};
+{{- end}}
+{{- else if ne .Module "proxy"}}
+        {{.Module}}_pass {{.Pass}};
+{{- if eq .Module "grpc"}}
+        grpc_set_header Host $host;
+        grpc_set_header X-Real-IP $remote_addr;
+        grpc_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        grpc_set_header X-Forwarded-Proto $scheme;
+{{- else}}
+        include {{.Module}}_params;
+{{- end}}
+{{- if eq .Module "fastcgi"}}
+        fastcgi_param SCRIPT_FILENAME {{.ScriptFilename}};
+{{- if and .Backend .Backend.Keepalive}}
+        fastcgi_keep_conn on;
+{{- end}}
+{{- end}}
+{{- with .ReadTimeout}}
+        {{$.Module}}_read_timeout {{.}};
+{{- end}}
+{{- if .CORSRule}}
+        {{.Module}}_hide_header Access-Control-Allow-Origin;
+        {{.Module}}_hide_header Access-Control-Allow-Credentials;
+{{- end}}
+{{- else}}
+{{- with .RewriteRule}}
+        rewrite "{{.Pattern}}" "{{.Target}}" break;
+{{- end}}
+        proxy_pass {{.Pass}};
+        include proxy_params;
+{{- if eq .Protocol "https"}}
+        proxy_ssl_server_name on;
+{{- end}}
+{{- with .RewriteRule}}{{with .Prefix}}
+        proxy_set_header X-Forwarded-Prefix {{.}};
+{{- end}}{{end}}
+{{- if .WebSocket}}
+        proxy_http_version 1.1;
+        proxy_set_header Upgrade $http_upgrade;
+        proxy_set_header Connection $connection_upgrade;
+{{- else if and .Backend .Backend.Keepalive}}
+        proxy_http_version 1.1;
+        proxy_set_header Connection "";
+{{- end}}
+{{- if .Streaming}}
+        proxy_buffering off;
+        proxy_request_buffering off;
+{{- end}}
+{{- with .ReadTimeout}}
+        proxy_read_timeout {{.}};
+{{- end}}
+{{- with .CacheRule}}
+        proxy_cache {{.Zone}};
+{{- range .Valid}}
+        proxy_cache_valid {{.Status}} {{.Time}};
+{{- end}}
+{{- with .Key}}
+        proxy_cache_key {{quote .}};
+{{- end}}
+{{- with .Bypass}}
+        proxy_cache_bypass {{.}};
+        proxy_no_cache {{.}};
+{{- end}}
+{{- end}}
+{{- if .CORSRule}}
+        proxy_hide_header Access-Control-Allow-Origin;
+        proxy_hide_header Access-Control-Allow-Credentials;
+{{- end}}
+{{- end}}
+{{- range .TuningDirectives}}
+        {{.}};
+{{- end}}
+{{- with .Headers}}
+{{- range .Set}}
+        {{$.Module}}_set_header {{.Name}} {{quote .Value}};
+{{- end}}
+{{- range .Hide}}
+        {{$.Module}}_hide_header {{.}};
+{{- end}}
+{{- range .Add}}
+        add_header {{.Name}} {{quote .Value}}{{if .Always}} always{{end}};
+{{- end}}
+{{- end}}
+        {{.CustomTags}}
+    }{{end}}
+
+{{- define "cors_headers"}}
+        add_header Access-Control-Allow-Origin {{.Origin}} always;
+{{- if .Credentials}}
+        add_header Access-Control-Allow-Credentials true always;
+{{- end}}
+{{- if .Vary}}
+        add_header Vary Origin always;
+{{- end}}
+{{- end}}
+
+{{- define "streams"}}{{range .}}{{template "stream" .}}
+{{end}}{{end}}
+
+{{- define "stream"}}server {
+    listen {{.Listen}}{{if eq .Protocol "udp"}} udp{{end}};
+    proxy_pass {{.Upstream}};
+{{- with .ConnectTimeout}}
+    proxy_connect_timeout {{.}};
+{{- end}}
+{{- with .Timeout}}
+    proxy_timeout {{.}};
+{{- end}}
+}
+{{end}}
+`
+
+// templateFuncs are helper functions available in templates
+var templateFuncs = template.FuncMap{
+	"indent": indent,
+	"join":   strings.Join,
+	"lower":  strings.ToLower,
+	"quote":  quote,
+	"upper":  strings.ToUpper,
+}
+
+// indent prefixes every line of text except the first one with level*4 spaces
+func indent(level int, text string) string {
+	return strings.Replace(text, "\n", "\n"+strings.Repeat("    ", level), -1)
+}
+
+var defaultRenderer = mustRenderer(NewRenderer(""))
+
+func mustRenderer(r *Renderer, err error) *Renderer {
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Renderer renders nginx config with text/template
+type Renderer struct {
+	tmpl *template.Template
+}
+
+// NewRenderer creates a Renderer with default templates. If dir is not empty,
+// all *.tmpl files in it are parsed after default ones, so templates defined
+// in these files take precedence.
+func NewRenderer(dir string) (*Renderer, error) {
+	tmpl, err := template.New("default").Funcs(templateFuncs).Parse(defaultTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no template found in %s", dir)
+		}
+		if tmpl, err = tmpl.ParseFiles(files...); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &Renderer{tmpl}
+	sample := sampleDataset()
+	if err = r.Config(&bytes.Buffer{}, sample); err != nil {
+		return nil, err
+	}
+	if err = r.Streams(&bytes.Buffer{}, sample); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// sampleDataset is used to check if templates work
+func sampleDataset() *Dataset {
+	s := NewServer("")
+	s.Create("/", "http://127.0.0.1", "")
+	s.CreateMapping("/pool/", &Mapping{
+		Pool:      "sample",
+		WebSocket: true,
+		Rewrite:   &Rewrite{Mode: RewriteStrip},
+		Auth:      "sample",
+		CORS:      &CORS{Origins: []string{"http://localhost:3000"}, Credentials: true, MaxAge: 600},
+		RateLimit: &RateLimit{Rate: 10, Burst: 20, NoDelay: true},
+		Cache:     &Cache{Enabled: true, Valid: map[string]string{"200": "10m"}, Bypass: "X-No-Cache"},
+		Headers: &HeaderRules{
+			Set:  []*Header{{Name: "X-Sample", Value: "$host"}},
+			Add:  []*Header{{Name: "X-Frame-Options", Value: "DENY", Always: true}},
+			Hide: []string{"X-Powered-By"},
+		},
+		Overrides: []*Override{
+			{Header: "X-Dev-Route", Value: "alice", Upstream: "10.0.0.2:8080"},
+			{Cookie: "dev_route", Value: "alice", Upstream: "sample"},
+		},
+	})
+	s.CreateMapping("/grpc/", &Mapping{Protocol: ProtocolGRPCS, Upstream: "127.0.0.1:50051", ReadTimeout: "1h"})
+	s.CreateMapping("/php/", &Mapping{Protocol: ProtocolFastCGI, Pool: "sample", ScriptRoot: "/var/www"})
+	s.CreateMapping("/uwsgi/", &Mapping{Protocol: ProtocolUWSGI, Upstream: "unix:/run/uwsgi.sock"})
+	s.CreateMapping("/redirect", &Mapping{Type: MappingRedirect, Redirect: &Redirect{Status: 301, Target: "/"}})
+	s.CreateMapping("/response", &Mapping{Type: MappingResponse, Response: &Response{Status: 204}})
+	s.CreateMapping("/static/", &Mapping{Type: MappingStatic, Static: &Static{Root: "/var/www", Alias: true, SPA: true}})
+
+	s.Auth = "sample"
+	s.Tuning = &Tuning{MaxBodySize: "1m", ConnectTimeout: "5s"}
+	s.Access = &Access{Rules: []*AccessRule{{Action: AccessAllow, List: "sample"}}, Default: AccessDeny}
+
+	named := NewServer("example.com")
+	named.Create("/", "http://127.0.0.1", "")
+	named.Names = []string{"example.com", "*.example.com", `~^(?<sub>\w+)\.example\.org$`}
+	named.Listens = []*Listen{{Port: 8080}, {Address: "::1", Port: 8080, IPv6: true}}
+
+	return &Dataset{
+		Servers:      []*NginxServer{s, named},
+		Pools:        []*Pool{{Name: "sample", Servers: []*PoolServer{{Address: "127.0.0.1:80"}}}},
+		Credentials:  []*CredentialSet{{Name: "sample", file: "/etc/nginx/htpasswd/sample"}},
+		AddressLists: []*AddressList{{Name: "sample", CIDRs: []string{"127.0.0.0/8"}}},
+		Streams: []*Stream{
+			{Name: "postgres", Port: 5432, Upstream: "127.0.0.1:5432", Timeout: "1h"},
+			{Name: "dns", Protocol: StreamUDP, Address: "127.0.0.1", Port: 53, Upstream: "127.0.0.1:5353"},
+		},
+	}
+}
+
+// configView is the data passed to "config" template
+type configView struct {
+	Pools   []*Pool
+	Servers []*serverView
+
+	// any mapping needs $connection_upgrade
+	Upgrade bool
+	// CORS rules needing origin map
+	CORS []*corsRule
+	// rate limits needing limit_req_zone
+	RateLimits []*rateLimitRule
+	// caches needing proxy_cache_path
+	Caches []*cacheRule
+	// map blocks selecting upstream overrides
+	Overrides []*overrideRule
+}
+
+// serverView is the data passed to "server" template
+type serverView struct {
+	*NginxServer
+	// first server name and port, kept for custom templates
+	Host    string
+	Port    string
+	Default bool
+
+	// arguments of server_name, empty for default server
+	Names []string
+	// listen addresses, and those of companion http server
+	Listens     []*listenView
+	HTTPListens []*listenView
+	// listen with cleartext http2 for gRPC mappings without https
+	HTTP2 bool
+
+	// redirect target of companion http server, empty if not needed
+	Redirect string
+	// directory of ACME challenge responses served by companion http server
+	Challenge string
+	// certificate managed by Yeast is not issued yet, only companion http
+	// server is rendered
+	Pending bool
+
+	// htpasswd file of Auth, empty if unknown
+	AuthFile string
+	// rendered Access, nil if not set
+	ACL []*accessDirective
+	// value of client_max_body_size
+	MaxBodySize string
+	// rendered Tuning except client_max_body_size
+	TuningDirectives []string
+
+	// enabled mappings, sorted by path
+	Locations []*locationView
+}
+
+// locationView is the data passed to "location" template
+type locationView struct {
+	Path string
+	*Mapping
+
+	// arguments of location directive, path with match modifier
+	Args string
+	// custom tags with secrets expanded
+	CustomTags string
+	// prefix of directives passing requests, like "proxy" or "fastcgi"
+	Module string
+	// argument of proxy_pass, grpc_pass, fastcgi_pass or uwsgi_pass
+	Pass string
+	// value of SCRIPT_FILENAME of fastcgi mapping
+	ScriptFilename string
+	// arguments of return directive, empty if proxying
+	Return string
+	// named location doing Return, empty if no check applies. return skips
+	// access checks and rate limits, so they are done in location of Path,
+	// which passes to this one by try_files.
+	Checked string
+	// argument of root or alias directive of static mapping
+	Root string
+	// arguments of try_files directive of static mapping
+	TryFiles string
+	// header rules sorted by name, nil if not set
+	Headers *HeaderRules
+	// rendered CORS, nil if not set
+	CORSRule *corsRule
+	// rendered RateLimit, nil if not set
+	RateLimitRule *rateLimitRule
+	// rendered Cache, nil if not set or disabled
+	CacheRule *cacheRule
+	// htpasswd file of Auth, empty if unknown
+	AuthFile string
+	// rendered Access, nil if not set
+	ACL []*accessDirective
+	// rendered Tuning
+	TuningDirectives []string
+	// the pool used by this mapping, nil if not using pool or unknown
+	Backend *Pool
+	// rendered Rewrite, nil if not rewriting
+	RewriteRule *rewriteRule
+	// map blocks selecting Pass, nil if no override
+	OverrideRules []*overrideRule
+	// implicit upstream segments of host names in OverrideRules
+	OverridePools []*Pool
+}
+
+// references holds named objects referred by servers and mappings
+type references struct {
+	pools map[string]*Pool
+	creds map[string]*CredentialSet
+	lists map[string]*AddressList
+	// where cached responses are put
+	cacheDir string
+}
+
+func newReferences(data *Dataset) *references {
+	ret := &references{
+		pools:    map[string]*Pool{},
+		creds:    map[string]*CredentialSet{},
+		lists:    map[string]*AddressList{},
+		cacheDir: data.cacheDir,
+	}
+	if ret.cacheDir == "" {
+		ret.cacheDir = defaultCacheDir
+	}
+	for _, pool := range data.Pools {
+		ret.pools[pool.Name] = pool
+	}
+	for _, c := range data.Credentials {
+		ret.creds[c.Name] = c
+	}
+	for _, l := range data.AddressLists {
+		ret.lists[l.Name] = l
+	}
+	return ret
+}
+
+// listenView is a rendered listen directive
+type listenView struct {
+	Addr    string
+	Default bool
+}
+
+// newServerView creates view of s, caller must hold read lock of s
+func newServerView(s *NginxServer, refs *references) *serverView {
+	ret := &serverView{
+		NginxServer: s,
+		Port:        s.port(),
+		Locations:   make([]*locationView, 0, len(s.Paths)),
+	}
+	for _, n := range s.names() {
+		ret.Names = append(ret.Names, serverNameArg(n))
+	}
+	if len(ret.Names) > 0 {
+		ret.Host = ret.Names[0]
+	}
+	seen := map[string]bool{}
+	for _, l := range s.listens() {
+		ret.Default = ret.Default || l.Default
+		ret.Listens = append(ret.Listens, &listenView{l.addr(), l.Default})
+
+		// companion http server listens on same address, but is never
+		// default server, which may be another server on port 80
+		l.Port = 80
+		if addr := l.addr(); !seen[addr] {
+			seen[addr] = true
+			ret.HTTPListens = append(ret.HTTPListens, &listenView{Addr: addr})
+		}
+	}
+	if c, ok := refs.creds[s.Auth]; ok {
+		ret.AuthFile = c.file
+	}
+	if s.Access != nil {
+		ret.ACL = s.Access.directives(refs.lists)
+	}
+	ret.MaxBodySize = defaultMaxBodySize
+	if s.Tuning != nil {
+		if s.Tuning.MaxBodySize != "" {
+			ret.MaxBodySize = s.Tuning.MaxBodySize
+		}
+		ret.TuningDirectives = s.Tuning.directives(false, "proxy")
+	}
+	if s.TLS != nil && s.TLS.Redirect {
+		ret.Redirect = "https://$host$request_uri"
+		if ret.Port != "443" {
+			ret.Redirect = "https://$host:" + ret.Port + "$request_uri"
+		}
+	}
+	if s.TLS != nil && s.TLS.Challenge != "" {
+		// https server is left out until certificate is issued by ACME CA
+		ret.Challenge = s.TLS.Challenge
+		if _, err := os.Stat(s.TLS.Certificate); err != nil {
+			ret.Pending = true
+		}
+	}
+
+	keys := mappingKeys{make([]string, 0, len(s.Paths)), s.Paths}
+	for key := range s.Paths {
+		keys.keys = append(keys.keys, key)
+	}
+	sort.Sort(keys)
+
+	for _, key := range keys.keys {
+		mapping := s.Paths[key]
+		if !mapping.Enabled {
+			continue
+		}
+		l := newLocationView(s, key, mapping, refs)
+		ret.HTTP2 = ret.HTTP2 || (s.TLS == nil && l.Module == "grpc")
+		ret.Locations = append(ret.Locations, l)
+	}
+
+	return ret
+}
+
+// locationID derives identifier of path in server, used to name variables and
+// zones in config
+func locationID(server, path string) string {
+	sum := sha1.Sum([]byte(server + " " + path))
+	return hex.EncodeToString(sum[:4])
+}
+
+// newLocationView creates view of mapping at key, caller must hold read lock
+// of s
+func newLocationView(s *NginxServer, key string, mapping *Mapping, refs *references) *locationView {
+	_, path := splitMatch(key)
+	id := locationID(s.ServerName, key)
+	ret := &locationView{
+		Path:       path,
+		Mapping:    mapping,
+		Args:       locationArgs(mapping.Match, path),
+		CustomTags: s.box.expand(mapping.CustomTags, mapping.Secrets),
+	}
+	if mapping.Headers != nil {
+		ret.Headers = mapping.Headers.sorted()
+	}
+	if mapping.CORS != nil {
+		ret.CORSRule = mapping.CORS.rule(id)
+	}
+	if mapping.RateLimit != nil {
+		ret.RateLimitRule = mapping.RateLimit.rule(id)
+	}
+	if mapping.Cache != nil && mapping.Cache.Enabled && mapping.proxied() {
+		ret.CacheRule = mapping.Cache.rule(id, refs.cacheDir)
+	}
+	if c, ok := refs.creds[mapping.Auth]; ok {
+		ret.AuthFile = c.file
+	}
+	if mapping.Access != nil {
+		ret.ACL = mapping.Access.directives(refs.lists)
+	}
+	tuning := mapping.Tuning
+	if module := mapping.module(); s.Tuning != nil && mapping.proxied() && module != "proxy" {
+		// server level ones are rendered as proxy_*, which other modules ignore
+		tuning = tuning.inherit(s.Tuning, module)
+		if mapping.ReadTimeout != "" {
+			tuning.ReadTimeout = ""
+		}
+	}
+	if tuning != nil {
+		ret.TuningDirectives = tuning.directives(true, mapping.module())
+	}
+
+	switch mapping.Type {
+	case MappingRedirect:
+		ret.Return = mapping.Redirect.args()
+		ret.Checked = checkedLocation(s, mapping, id)
+		return ret
+	case MappingResponse:
+		ret.Return = mapping.Response.args()
+		ret.Checked = checkedLocation(s, mapping, id)
+		return ret
+	case MappingStatic:
+		ret.Root = mapping.Static.root(path)
+		ret.TryFiles = mapping.Static.tryFiles(mapping.Match, path)
+		return ret
+	}
+
+	ret.Module = mapping.module()
+	ret.Pass = mapping.pass()
+	if ret.Module == "fastcgi" {
+		ret.ScriptFilename = mapping.scriptFilename()
+	}
+	if mapping.Pool != "" {
+		ret.Backend = refs.pools[mapping.Pool]
+	}
+	if mapping.Rewrite != nil {
+		ret.RewriteRule, ret.Pass = mapping.Rewrite.rule(path, ret.Pass)
+	}
+	if len(mapping.Overrides) > 0 {
+		ret.OverrideRules, ret.OverridePools = mapping.overrideRules(id, ret.Pass, refs.pools)
+		ret.Pass = ret.OverrideRules[0].Variable
+	}
+	return ret
+}
+
+// checkedLocation returns name of location returning response of mapping
+// after access checks and rate limit of it, empty if none applies
+func checkedLocation(s *NginxServer, mapping *Mapping, id string) string {
+	if s.Auth == "" && s.Access == nil && (mapping.Auth == "" || mapping.Auth == AuthOff) &&
+		mapping.Access == nil && mapping.RateLimit == nil {
+		return ""
+	}
+	return "@yeast_" + id
+}
+
+// Server renders server segment of s into w
+func (r *Renderer) Server(w io.Writer, s *NginxServer) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	return r.tmpl.ExecuteTemplate(w, "server", newServerView(s, newReferences(&Dataset{})))
+}
+
+// Config renders whole config file with data into w, servers and pools are
+// sorted by name.
+func (r *Renderer) Config(w io.Writer, data *Dataset) error {
+	sorted := make([]*NginxServer, len(data.Servers))
+	copy(sorted, data.Servers)
+	sort.Sort(byName(sorted))
+
+	view := &configView{
+		Pools:   make([]*Pool, len(data.Pools)),
+		Servers: make([]*serverView, 0, len(sorted)),
+	}
+	copy(view.Pools, data.Pools)
+	sort.Sort(poolsByName(view.Pools))
+	refs := newReferences(data)
+
+	for _, s := range sorted {
+		s.RLock()
+		defer s.RUnlock()
+		sv := newServerView(s, refs)
+		for _, l := range sv.Locations {
+			view.Upgrade = view.Upgrade || l.WebSocket
+			if l.CORSRule != nil && l.CORSRule.Variable != "" {
+				view.CORS = append(view.CORS, l.CORSRule)
+			}
+			if l.RateLimitRule != nil {
+				view.RateLimits = append(view.RateLimits, l.RateLimitRule)
+			}
+			if l.CacheRule != nil {
+				view.Caches = append(view.Caches, l.CacheRule)
+			}
+			view.Overrides = append(view.Overrides, l.OverrideRules...)
+			view.Pools = append(view.Pools, l.OverridePools...)
+		}
+		view.Servers = append(view.Servers, sv)
+	}
+
+	return r.tmpl.ExecuteTemplate(w, "config", view)
+}
+
+// Streams renders stream config file with streams in data into w, streams
+// are sorted by port.
+func (r *Renderer) Streams(w io.Writer, data *Dataset) error {
+	sorted := make([]*Stream, len(data.Streams))
+	copy(sorted, data.Streams)
+	sort.Sort(streamsByPort(sorted))
+
+	return r.tmpl.ExecuteTemplate(w, "streams", sorted)
+}
+
+type byName []*NginxServer
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].ServerName < s[j].ServerName }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type poolsByName []*Pool
+
+func (s poolsByName) Len() int           { return len(s) }
+func (s poolsByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s poolsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
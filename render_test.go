@@ -0,0 +1,128 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// golden files in testdata were generated by the builder used before
+// templates, default templates must reproduce them exactly.
+var goldenServers = map[string]func() *NginxServer{
+	"default_server": func() *NginxServer {
+		s := NewServer("")
+		s.Create("/b/", "http://b", "custom_tag 123;")
+		s.Create("/a/", "http://a", "")
+		return s
+	},
+	"named_server": func() *NginxServer {
+		s := NewServer("example.com")
+		s.Create("/api/", "http://127.0.0.1:8000", "proxy_read_timeout 300s;\nproxy_set_header X-A 1;")
+		s.Create("/", "http://frontend", "")
+		s.Create("/disabled/", "http://disabled", "")
+		s.Disable("/disabled/")
+		return s
+	},
+	"custom_port": func() *NginxServer {
+		s := NewServer("example.com:8443")
+		s.Create("/a/", "http://a", "")
+		return s
+	},
+	"empty_server": func() *NginxServer {
+		return NewServer("empty.example.com")
+	},
+}
+
+func golden(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name+".golden"))
+	if err != nil {
+		t.Fatalf("Cannot read golden file of %s: %s", name, err)
+	}
+	return string(data)
+}
+
+func TestRenderGolden(t *testing.T) {
+	for name, f := range goldenServers {
+		expect := golden(t, name)
+		if actual := f().Export(); actual != expect {
+			t.Errorf("%s differs from golden file, got:\n%s", name, actual)
+		}
+	}
+}
+
+func TestRenderConfig(t *testing.T) {
+	a := goldenServers["named_server"]()
+	b := goldenServers["default_server"]()
+	expect := golden(t, "default_server") + "\n" + golden(t, "named_server") + "\n"
+
+	buf := &bytes.Buffer{}
+	if err := defaultRenderer.Config(buf, &Dataset{Servers: []*NginxServer{a, b}}); err != nil {
+		t.Fatalf("Cannot render config: %s", err)
+	}
+	if actual := buf.String(); actual != expect {
+		t.Errorf("Config is not sorted golden files, got:\n%s", actual)
+	}
+}
+
+func TestRenderCustomTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tmpl")
+	if err != nil {
+		t.Fatalf("Cannot create template dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpl := `{{define "location"}}    location {{.Path}} {
+        proxy_pass {{.Upstream}};
+        {{indent 2 .CustomTags}}
+    }{{end}}`
+	ioutil.WriteFile(filepath.Join(dir, "location.tmpl"), []byte(tmpl), 0644)
+
+	r, err := NewRenderer(dir)
+	if err != nil {
+		t.Fatalf("Cannot load custom template: %s", err)
+	}
+
+	expect := `server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 80;
+
+    location /a/ {
+        proxy_pass http://a;
+        a 1;
+        b 2;
+    }
+
+}`
+	s := NewServer("example.com")
+	s.Create("/a/", "http://a", "a 1;\nb 2;")
+	buf := &bytes.Buffer{}
+	if err := r.Server(buf, s); err != nil {
+		t.Fatalf("Cannot render with custom template: %s", err)
+	}
+	if actual := buf.String(); actual != expect {
+		t.Errorf("Custom template is not used, got:\n%s", actual)
+	}
+}
+
+func TestRenderBrokenTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tmpl")
+	if err != nil {
+		t.Fatalf("Cannot create template dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "server.tmpl"), []byte(`{{define "server"}}{{.NoSuchField}}{{end}}`), 0644)
+	if _, err := NewRenderer(dir); err == nil {
+		t.Error("Broken template should be rejected")
+	}
+
+	if _, err := NewRenderer(filepath.Join(dir, "not-exist")); err == nil {
+		t.Error("Empty template dir should be rejected")
+	}
+}
@@ -0,0 +1,103 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// types of Mapping
+const (
+	// MappingProxy passes requests to Upstream or Pool
+	MappingProxy = "proxy"
+	// MappingRedirect redirects requests to another url
+	MappingRedirect = "redirect"
+	// MappingResponse returns fixed response
+	MappingResponse = "response"
+)
+
+// Redirect describes where to redirect requests
+type Redirect struct {
+	Status int    `json:"status"`
+	Target string `json:"target"`
+	// append request uri to Target
+	KeepURI bool `json:"keep_uri,omitempty"`
+}
+
+// Validate checks if r is malformed
+func (r *Redirect) Validate() error {
+	switch r.Status {
+	case 301, 302, 303, 307, 308:
+	default:
+		return fmt.Errorf("invalid redirect status %d", r.Status)
+	}
+
+	if r.Target == "" {
+		return fmt.Errorf("redirect target is required")
+	}
+	if strings.ContainsAny(r.Target, " \t\n;{}\"'") {
+		return fmt.Errorf("redirect target %s contains invalid characters", r.Target)
+	}
+	return nil
+}
+
+// args returns arguments of return directive
+func (r *Redirect) args() string {
+	target := r.Target
+	if r.KeepURI {
+		target = strings.TrimSuffix(target, "/") + "$request_uri"
+	}
+	return strconv.Itoa(r.Status) + " " + target
+}
+
+// Response describes fixed response
+type Response struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body,omitempty"`
+}
+
+// Validate checks if r is malformed
+func (r *Response) Validate() error {
+	if r.Status < 100 || r.Status > 599 {
+		return fmt.Errorf("invalid response status %d", r.Status)
+	}
+	switch r.Status {
+	case 301, 302, 303, 307, 308:
+		return fmt.Errorf("use redirect mapping for status %d", r.Status)
+	}
+
+	if r.ContentType != "" {
+		// parameters like charset are allowed, value is quoted when rendering
+		if _, _, err := mime.ParseMediaType(r.ContentType); err != nil || strings.ContainsAny(r.ContentType, "\r\n") {
+			return fmt.Errorf("invalid content type %q", r.ContentType)
+		}
+	}
+	// nginx expands variables in body, and $ cannot be escaped
+	if strings.Contains(r.Body, "$") {
+		return fmt.Errorf("response body cannot contain $")
+	}
+	return nil
+}
+
+// bodyEscaper escapes response body into double quoted nginx string
+var bodyEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+)
+
+// args returns arguments of return directive
+func (r *Response) args() string {
+	ret := strconv.Itoa(r.Status)
+	if r.Body != "" {
+		ret += ` "` + bodyEscaper.Replace(r.Body) + `"`
+	}
+	return ret
+}
@@ -0,0 +1,150 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMappingTypeValidate(t *testing.T) {
+	valid := []*Mapping{
+		{Upstream: "http://a"},
+		{Type: MappingProxy, Pool: "a"},
+		{Type: MappingRedirect, Redirect: &Redirect{Status: 301, Target: "https://wiki.example.com/docs"}},
+		{Type: MappingRedirect, Redirect: &Redirect{Status: 308, Target: "https://example.com", KeepURI: true}},
+		{Type: MappingResponse, Response: &Response{Status: 204}},
+		{Type: MappingResponse, Response: &Response{Status: 200, ContentType: "text/plain", Body: "User-agent: *\nDisallow: /"}},
+		{Type: MappingResponse, Response: &Response{Status: 200, ContentType: `text/plain; charset="utf-8"`}},
+	}
+	for _, m := range valid {
+		if err := m.Validate("/a"); err != nil {
+			t.Errorf("Valid mapping %#v is rejected: %s", m, err)
+		}
+	}
+
+	invalid := []*Mapping{
+		{Type: "file"},
+		{Upstream: "http://a", Redirect: &Redirect{Status: 301, Target: "/"}},
+		{Type: MappingRedirect},
+		{Type: MappingRedirect, Upstream: "http://a", Redirect: &Redirect{Status: 301, Target: "/"}},
+		{Type: MappingRedirect, Redirect: &Redirect{Status: 200, Target: "/"}},
+		{Type: MappingRedirect, Redirect: &Redirect{Status: 301}},
+		{Type: MappingRedirect, Redirect: &Redirect{Status: 301, Target: "/a b"}},
+		{Type: MappingResponse, Response: &Response{Status: 600}},
+		{Type: MappingResponse, Response: &Response{Status: 302, Body: "/"}},
+		{Type: MappingResponse, Response: &Response{Status: 200, ContentType: "text/plain; charset"}},
+		{Type: MappingResponse, Response: &Response{Status: 200, ContentType: "text/plain;\ncharset=utf-8"}},
+		{Type: MappingResponse, Response: &Response{Status: 200, Body: "price: $remote_addr"}},
+		{Type: MappingResponse, Response: &Response{Status: 200}, Redirect: &Redirect{Status: 301, Target: "/"}},
+	}
+	for _, m := range invalid {
+		if m.Validate("/a") == nil {
+			t.Errorf("Invalid mapping %#v is accepted", m)
+		}
+	}
+}
+
+func TestResponseExport(t *testing.T) {
+	expect := `server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 80;
+
+    location /docs {
+        return 302 https://wiki.example.com$request_uri;
+        
+    }
+
+    location = /healthz {
+        return 204;
+        
+    }
+
+    location = /robots.txt {
+        default_type "text/plain; charset=utf-8";
+        return 200 "User-agent: *\nDisallow: \"/\"";
+        
+    }
+
+}`
+
+	s := NewServer("example.com")
+	s.CreateMapping("/docs", &Mapping{
+		Type:     MappingRedirect,
+		Redirect: &Redirect{Status: 302, Target: "https://wiki.example.com/", KeepURI: true},
+	})
+	s.CreateMapping("/healthz", &Mapping{
+		Type:     MappingResponse,
+		Match:    MatchExact,
+		Response: &Response{Status: 204},
+	})
+	s.CreateMapping("/robots.txt", &Mapping{
+		Type:     MappingResponse,
+		Match:    MatchExact,
+		Response: &Response{Status: 200, ContentType: "text/plain; charset=utf-8", Body: "User-agent: *\nDisallow: \"/\""},
+	})
+	if actual := s.Export(); actual != expect {
+		t.Errorf("Response returns %s", actual)
+	}
+}
+
+func TestResponseChecked(t *testing.T) {
+	id := locationID("example.com", "= /status")
+	old := locationID("example.com", "/old")
+	expect := `server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 80;
+    deny 10.0.0.0/8;
+    allow all;
+
+    location /old {
+        try_files /dev/null @yeast_` + old + `;
+    }
+
+    location @yeast_` + old + ` {
+        auth_basic off;
+        allow all;
+        return 301 /new;
+        
+    }
+
+    location = /status {
+        allow 192.168.0.0/24;
+        deny all;
+        limit_req zone=rate_` + id + ` burst=5;
+        limit_req_status 429;
+        try_files /dev/null @yeast_` + id + `;
+    }
+
+    location @yeast_` + id + ` {
+        auth_basic off;
+        allow all;
+        default_type "text/plain";
+        return 200 "ok";
+        
+    }
+
+}`
+
+	s := NewServer("example.com")
+	s.Access = &Access{Rules: []*AccessRule{{Action: AccessDeny, CIDR: "10.0.0.0/8"}}, Default: AccessAllow}
+	s.CreateMapping("/status", &Mapping{
+		Type:      MappingResponse,
+		Match:     MatchExact,
+		Response:  &Response{Status: 200, ContentType: "text/plain", Body: "ok"},
+		Access:    &Access{Rules: []*AccessRule{{Action: AccessAllow, CIDR: "192.168.0.0/24"}}, Default: AccessDeny},
+		RateLimit: &RateLimit{Rate: 1, Burst: 5},
+	})
+	s.CreateMapping("/old", &Mapping{Type: MappingRedirect, Redirect: &Redirect{Status: 301, Target: "/new"}})
+	if actual := s.Export(); actual != expect {
+		t.Errorf("Checks are skipped by return, got:\n%s", actual)
+	}
+
+	s.Access = nil
+	if actual := s.Export(); !strings.Contains(actual, "    location /old {\n        return 301 /new;") {
+		t.Errorf("Unchecked redirect is passed to named location, got:\n%s", actual)
+	}
+}
@@ -0,0 +1,109 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// modes of Rewrite
+const (
+	// RewriteStrip removes location path from request uri
+	RewriteStrip = "strip"
+	// RewriteReplace replaces location path with Replace
+	RewriteReplace = "replace"
+	// RewriteRegex rewrites request uri matching Pattern to Target
+	RewriteRegex = "regex"
+)
+
+// Rewrite describes how request uri is changed before passing to upstream
+type Rewrite struct {
+	Mode    string `json:"mode"`
+	Replace string `json:"replace,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	Target  string `json:"target,omitempty"`
+}
+
+// Validate checks if r is malformed, path is the location it is used in
+func (r *Rewrite) Validate(path string) error {
+	switch r.Mode {
+	case RewriteStrip:
+		if strings.TrimSuffix(path, "/") == "" {
+			return fmt.Errorf("nothing to strip from %s", path)
+		}
+	case RewriteReplace:
+		if !strings.HasPrefix(r.Replace, "/") {
+			return fmt.Errorf("replacement %q should start with /", r.Replace)
+		}
+	case RewriteRegex:
+		if err := checkRegex(r.Pattern); err != nil {
+			return fmt.Errorf("invalid rewrite pattern: %s", err)
+		}
+		if !strings.HasPrefix(r.Target, "/") {
+			return fmt.Errorf("rewrite target %q should start with /", r.Target)
+		}
+	default:
+		return fmt.Errorf("unknown rewrite mode %s", r.Mode)
+	}
+
+	if strings.ContainsAny(r.Replace+r.Pattern+r.Target, "\"\n") {
+		return fmt.Errorf("rewrite contains invalid characters")
+	}
+	return nil
+}
+
+// rewriteRule is rendered form of Rewrite
+type rewriteRule struct {
+	Pattern string
+	Target  string
+
+	// value of X-Forwarded-Prefix, empty if no prefix is removed
+	Prefix string
+}
+
+// splitUpstream splits "http://host/path" into "http://host" and "/path"
+func splitUpstream(upstream string) (host, uri string) {
+	start := strings.Index(upstream, "://")
+	if start < 0 {
+		return upstream, ""
+	}
+
+	idx := strings.Index(upstream[start+3:], "/")
+	if idx < 0 {
+		return upstream, ""
+	}
+	idx += start + 3
+	return upstream[:idx], upstream[idx:]
+}
+
+// rule generates rewrite directive used in location path proxying to pass.
+//
+// Uri part of pass is moved into rewrite target, since nginx ignores it when
+// uri is rewritten, so the returned pass contains only scheme and host.
+func (r *Rewrite) rule(path, pass string) (*rewriteRule, string) {
+	host, base := splitUpstream(pass)
+	base = strings.TrimSuffix(base, "/")
+
+	if r.Mode == RewriteRegex {
+		return &rewriteRule{
+			Pattern: r.Pattern,
+			Target:  base + r.Target,
+		}, host
+	}
+
+	prefix := strings.TrimSuffix(path, "/")
+	replace := "/"
+	if r.Mode == RewriteReplace {
+		replace = strings.TrimSuffix(r.Replace, "/") + "/"
+	}
+
+	// prefix matches only at segment boundary, with or without trailing slash
+	return &rewriteRule{
+		Pattern: "^" + regexp.QuoteMeta(prefix) + "(?:/(.*))?$",
+		Target:  base + replace + "$1",
+		Prefix:  prefix,
+	}, host
+}
@@ -0,0 +1,114 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// proxied simulates nginx, returns uri received by upstream
+func proxied(t *testing.T, r *Rewrite, path, upstream, uri string) string {
+	rule, pass := r.rule(path, upstream)
+	if _, u := splitUpstream(pass); u != "" {
+		t.Fatalf("proxy_pass %s should not contain uri when rewriting", pass)
+	}
+
+	re := regexp.MustCompile(rule.Pattern)
+	if !re.MatchString(uri) {
+		return uri
+	}
+	return re.ReplaceAllString(uri, strings.Replace(rule.Target, "$1", "${1}", -1))
+}
+
+func TestRewriteTrailingSlash(t *testing.T) {
+	strip := &Rewrite{Mode: RewriteStrip}
+	replace := &Rewrite{Mode: RewriteReplace, Replace: "/v2"}
+	cases := []struct {
+		rewrite  *Rewrite
+		path     string
+		upstream string
+		uri      string
+		expect   string
+	}{
+		{strip, "/svc/orders/", "http://b", "/svc/orders/", "/"},
+		{strip, "/svc/orders/", "http://b", "/svc/orders/1/items", "/1/items"},
+		{strip, "/svc/orders/", "http://b/", "/svc/orders/1", "/1"},
+		{strip, "/svc/orders", "http://b", "/svc/orders", "/"},
+		{strip, "/svc/orders", "http://b", "/svc/orders/1", "/1"},
+		{strip, "/svc/orders", "http://b/", "/svc/orders/1", "/1"},
+		// not at segment boundary, passed as is
+		{strip, "/svc/orders", "http://b", "/svc/orders-v2/1", "/svc/orders-v2/1"},
+		{strip, "/svc/orders/", "http://b/api", "/svc/orders/1", "/api/1"},
+		{strip, "/svc/orders/", "http://b/api/", "/svc/orders/1", "/api/1"},
+		{strip, "/svc/orders", "http://b/api/", "/svc/orders", "/api/"},
+		{strip, "/svc.v1/", "http://b", "/svcXv1/1", "/svcXv1/1"},
+		{replace, "/svc/orders/", "http://b", "/svc/orders/1", "/v2/1"},
+		{replace, "/svc/orders", "http://b/", "/svc/orders", "/v2/"},
+		{&Rewrite{Mode: RewriteReplace, Replace: "/v2/"}, "/svc/orders/", "http://b/api", "/svc/orders/1", "/api/v2/1"},
+		{&Rewrite{Mode: RewriteRegex, Pattern: `^/svc/(\w+)/(.*)$`, Target: "/$2"}, "/svc/", "http://b", "/svc/orders/1", "/1"},
+		{&Rewrite{Mode: RewriteRegex, Pattern: `^/svc/(\w+)/(.*)$`, Target: "/$2"}, "/svc/", "http://b/api", "/svc/orders/1", "/api/1"},
+	}
+
+	for _, c := range cases {
+		if actual := proxied(t, c.rewrite, c.path, c.upstream, c.uri); actual != c.expect {
+			t.Errorf("%s with %#v in %s to %s: expect %s, got %s", c.uri, c.rewrite, c.path, c.upstream, c.expect, actual)
+		}
+	}
+}
+
+func TestRewriteValidate(t *testing.T) {
+	valid := map[string]*Rewrite{
+		"/svc/": {Mode: RewriteStrip},
+		"/a/":   {Mode: RewriteReplace, Replace: "/b/"},
+		"/":     {Mode: RewriteRegex, Pattern: `^/a/(.*)$`, Target: "/b/$1"},
+	}
+	for path, r := range valid {
+		if err := r.Validate(path); err != nil {
+			t.Errorf("Valid rewrite %#v is rejected: %s", r, err)
+		}
+	}
+
+	invalid := map[string]*Rewrite{
+		"/":    {Mode: RewriteStrip},
+		"/a/":  {Mode: RewriteReplace, Replace: "b"},
+		"/b/":  {Mode: RewriteRegex, Pattern: `^/a/(.*$`, Target: "/b/$1"},
+		"/c/":  {Mode: RewriteRegex, Pattern: `^/a/(.*)$`, Target: "b"},
+		"/d/":  {Mode: RewriteRegex, Pattern: `^/a/"(.*)$`, Target: "/b"},
+		"/e/":  {Mode: "move"},
+		"/ab/": {Mode: RewriteReplace, Replace: "/b\n"},
+	}
+	for path, r := range invalid {
+		if r.Validate(path) == nil {
+			t.Errorf("Invalid rewrite %#v is accepted", r)
+		}
+	}
+}
+
+func TestRewriteExport(t *testing.T) {
+	expect := `server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 80;
+
+    location /svc/orders/ {
+        rewrite "^/svc/orders(?:/(.*))?$" "/api/$1" break;
+        proxy_pass http://orders;
+        include proxy_params;
+        proxy_set_header X-Forwarded-Prefix /svc/orders;
+        
+    }
+
+}`
+
+	s := NewServer("example.com")
+	s.CreateMapping("/svc/orders/", &Mapping{
+		Upstream: "http://orders/api/",
+		Rewrite:  &Rewrite{Mode: RewriteStrip},
+	})
+	if actual := s.Export(); actual != expect {
+		t.Errorf("Rewrite returns %s", actual)
+	}
+}
@@ -0,0 +1,181 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+const (
+	// SecretMask replaces secret values in api responses. Passing it back
+	// when modifying keeps the stored value.
+	SecretMask = "******"
+
+	// SecretKeyEnv is the environment variable holding encryption key
+	SecretKeyEnv = "YEAST_SECRET_KEY"
+
+	sealedPrefix = "enc:"
+)
+
+// secretRef matches {{secret:name}} in custom tags
+var secretRef = regexp.MustCompile(`\{\{secret:([A-Za-z0-9_]+)\}\}`)
+
+// SecretBox encrypts secret values of mappings with AES-GCM
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox creates a SecretBox, the real key is derived from sha256 of key
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	key = bytes.TrimSpace(key)
+	if len(key) == 0 {
+		return nil, errors.New("secret key is empty")
+	}
+
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretBox{aead}, nil
+}
+
+// LoadSecretBox creates a SecretBox with key read from keyFile, or from
+// environment value env if keyFile is empty. It returns nil if neither is
+// given.
+func LoadSecretBox(keyFile, env string) (*SecretBox, error) {
+	key := []byte(env)
+	if keyFile != "" {
+		data, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = data
+	}
+
+	if len(key) == 0 {
+		return nil, nil
+	}
+	return NewSecretBox(key)
+}
+
+// Seal encrypts plain text. It always encrypts, even if plain looks like a
+// sealed value, since plain comes from user.
+func (b *SecretBox) Seal(plain string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	data := b.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Open decrypts value returned by Seal
+func (b *SecretBox) Open(sealed string) (string, error) {
+	if !isSealed(sealed) {
+		return "", errors.New("value is not encrypted")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed[len(sealedPrefix):])
+	if err != nil {
+		return "", err
+	}
+
+	size := b.aead.NonceSize()
+	if len(data) < size {
+		return "", errors.New("encrypted value is too short")
+	}
+
+	plain, err := b.aead.Open(nil, data[:size], data[size:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func isSealed(val string) bool {
+	return strings.HasPrefix(val, sealedPrefix)
+}
+
+// sealMapping encrypts all plain secret values of m in place. Masked values
+// are left for NginxServer to restore.
+func (b *SecretBox) sealMapping(m *Mapping) error {
+	if len(m.Secrets) == 0 {
+		return nil
+	}
+	if b == nil {
+		return errors.New("no secret key configured, cannot store secrets")
+	}
+
+	for name, val := range m.Secrets {
+		if val == SecretMask {
+			continue
+		}
+		sealed, err := b.Seal(val)
+		if err != nil {
+			return err
+		}
+		m.Secrets[name] = sealed
+	}
+	return nil
+}
+
+// checkMasked returns ErrMaskedSecret if a masked secret of m is not in stored
+// secrets, which are kept in place of masked ones
+func checkMasked(m *Mapping, stored map[string]string) error {
+	for name, val := range m.Secrets {
+		if _, ok := stored[name]; val == SecretMask && !ok {
+			return ErrMaskedSecret
+		}
+	}
+	return nil
+}
+
+// expand replaces secret references in text with decrypted values. References
+// are left untouched if they cannot be decrypted.
+func (b *SecretBox) expand(text string, secrets map[string]string) string {
+	if b == nil || len(secrets) == 0 {
+		return text
+	}
+
+	return secretRef.ReplaceAllStringFunc(text, func(ref string) string {
+		name := secretRef.FindStringSubmatch(ref)[1]
+		plain, err := b.Open(secrets[name])
+		if err != nil {
+			return ref
+		}
+		return plain
+	})
+}
+
+// masked returns a copy of m with secret values hidden
+func (m *Mapping) masked() *Mapping {
+	if len(m.Secrets) == 0 {
+		return m
+	}
+
+	ret := *m
+	ret.Secrets = make(map[string]string, len(m.Secrets))
+	for name := range m.Secrets {
+		ret.Secrets[name] = SecretMask
+	}
+	return &ret
+}
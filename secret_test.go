@@ -0,0 +1,179 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSecretSealOpen(t *testing.T) {
+	box, _ := NewSecretBox([]byte("my key\n"))
+	sealed, err := box.Seal("Bearer 123")
+	if err != nil {
+		t.Fatalf("Cannot seal: %s", err)
+	}
+	if strings.Contains(sealed, "Bearer") {
+		t.Errorf("Sealed value contains plain text: %s", sealed)
+	}
+
+	plain, err := box.Open(sealed)
+	if err != nil || plain != "Bearer 123" {
+		t.Errorf("Open returns %s, %s", plain, err)
+	}
+
+	other, _ := NewSecretBox([]byte("other key"))
+	if _, err := other.Open(sealed); err == nil {
+		t.Error("Sealed value can be opened with wrong key")
+	}
+}
+
+func TestSecretSealPrefixed(t *testing.T) {
+	box, _ := NewSecretBox([]byte("my key"))
+	sealed, err := box.Seal("enc:not-really-sealed")
+	if err != nil {
+		t.Fatalf("Cannot seal: %s", err)
+	}
+	if strings.Contains(sealed, "not-really-sealed") {
+		t.Errorf("Value starting with enc: is stored in plain text: %s", sealed)
+	}
+
+	plain, err := box.Open(sealed)
+	if err != nil || plain != "enc:not-really-sealed" {
+		t.Errorf("Open returns %s, %s", plain, err)
+	}
+}
+
+func TestSecretLoadBox(t *testing.T) {
+	box, err := LoadSecretBox("", "")
+	if box != nil || err != nil {
+		t.Errorf("Expect no box without key, got %v, %s", box, err)
+	}
+
+	if box, err = LoadSecretBox("", "env key"); box == nil || err != nil {
+		t.Errorf("Cannot create box from environment: %s", err)
+	}
+}
+
+func TestSecretExport(t *testing.T) {
+	expect := `server {
+    client_max_body_size 250m;
+    listen 80 default_server;
+
+    location /a/ {
+        proxy_pass http://a;
+        include proxy_params;
+        proxy_set_header Authorization "Bearer 123";
+    }
+
+}`
+
+	box, _ := NewSecretBox([]byte("my key"))
+	m := &Mapping{
+		Upstream:   "http://a",
+		CustomTags: `proxy_set_header Authorization "{{secret:token}}";`,
+		Secrets:    map[string]string{"token": "Bearer 123"},
+	}
+	box.sealMapping(m)
+
+	s := NewServer("")
+	s.box = box
+	s.CreateMapping("/a/", m)
+	actual := s.Export()
+
+	if actual != expect {
+		t.Errorf("Secret is not decrypted when exporting: %s", actual)
+	}
+}
+
+func TestSecretValidate(t *testing.T) {
+	m := &Mapping{
+		Upstream:   "http://a",
+		CustomTags: `proxy_set_header Authorization "{{secret:token}}";`,
+	}
+	if m.Validate("/a/") == nil {
+		t.Error("Referring undefined secret should be rejected")
+	}
+}
+
+func TestSecretMask(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+	box, _ := NewSecretBox([]byte("my key"))
+	p.SetSecretBox(box)
+
+	m := &Mapping{Upstream: "http://a", Secrets: map[string]string{"token": "123"}}
+	if err := p.Seal(m); err != nil {
+		t.Fatalf("Cannot seal mapping: %s", err)
+	}
+	p.CreateMapping("test.server", "/test/", m)
+	sealed := m.Secrets["token"]
+
+	if masked := m.masked(); masked.Secrets["token"] != SecretMask {
+		t.Errorf("Secret is not masked: %s", masked.Secrets["token"])
+	}
+	if m.Secrets["token"] != sealed {
+		t.Error("Masking modifies original mapping")
+	}
+
+	p.ModifyMapping("test.server", "/test/", "/test/", &Mapping{
+		Upstream: "http://b",
+		Secrets:  map[string]string{"token": SecretMask},
+	})
+	if actual := p.List()["test.server"].Paths["/test/"].Secrets["token"]; actual != sealed {
+		t.Errorf("Masked secret is not kept when modifying, got %s", actual)
+	}
+
+	unknown := &Mapping{
+		Upstream:   "http://b",
+		CustomTags: "proxy_set_header X-Key {{secret:key}};",
+		Secrets:    map[string]string{"token": SecretMask, "key": SecretMask},
+	}
+	if _, err := p.ModifyMapping("test.server", "/test/", "/test/", unknown); err != ErrMaskedSecret {
+		t.Errorf("Masked secret without stored value should be rejected, got %v", err)
+	}
+	if _, err := p.CreateMapping("test.server", "/new/", unknown); err != ErrMaskedSecret {
+		t.Errorf("Masked secret of new mapping should be rejected, got %v", err)
+	}
+	if actual := p.List()["test.server"].Paths["/test/"].Upstream; actual != "http://b" {
+		t.Errorf("Rejected mapping replaces the stored one, got upstream %s", actual)
+	}
+}
+
+func TestSecretNoKey(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	m := &Mapping{Upstream: "http://a", Secrets: map[string]string{"token": "123"}}
+	if err := p.Seal(m); err == nil {
+		t.Error("Secrets should be rejected without key")
+	}
+}
+
+func TestSecretLoad(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+	box, _ := NewSecretBox([]byte("my key"))
+	p.SetSecretBox(box)
+
+	m := &Mapping{Upstream: "http://a", Secrets: map[string]string{"token": "123"}}
+	p.Seal(m)
+	p.CreateMapping("test.server", "/test/", m)
+
+	loader := NewPersistor(p.filename, p.conffile)
+	if err := loader.Load(); err == nil {
+		t.Error("Loading secrets without key should fail")
+	}
+
+	other, _ := NewSecretBox([]byte("other key"))
+	loader.SetSecretBox(other)
+	if err := loader.Load(); err == nil {
+		t.Error("Loading secrets with wrong key should fail")
+	}
+
+	loader.SetSecretBox(box)
+	if err := loader.Load(); err != nil {
+		t.Errorf("Cannot load secrets with correct key: %s", err)
+	}
+}
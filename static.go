@@ -0,0 +1,77 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MappingStatic serves files in a directory
+const MappingStatic = "static"
+
+// Static describes how files are served
+type Static struct {
+	// directory of files
+	Root string `json:"root"`
+	// use alias instead of root, so location path is not part of file path
+	Alias bool `json:"alias,omitempty"`
+	// index files, separated by space, nginx default if empty
+	Index     string `json:"index,omitempty"`
+	AutoIndex bool   `json:"autoindex,omitempty"`
+	// serve index file for unknown paths, for single-page apps
+	SPA bool `json:"spa,omitempty"`
+}
+
+// Validate checks if s is malformed, match and path are how it is mapped
+func (s *Static) Validate(match, path string) error {
+	if !filepath.IsAbs(s.Root) {
+		return fmt.Errorf("static root %s should be absolute path", s.Root)
+	}
+	if strings.ContainsAny(s.Root+s.Index, "\t\n;{}\"'") || strings.Contains(s.Root, " ") {
+		return fmt.Errorf("static settings contain invalid characters")
+	}
+	if s.Alias && isRegexMatch(match) {
+		return fmt.Errorf("alias cannot be used with regular expression")
+	}
+
+	info, err := os.Stat(s.Root)
+	if err != nil {
+		return fmt.Errorf("cannot access static root: %s", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("static root %s is not a directory", s.Root)
+	}
+	return nil
+}
+
+// root returns argument of root or alias directive
+func (s *Static) root(path string) string {
+	ret := strings.TrimSuffix(s.Root, "/")
+	if ret == "" || (s.Alias && strings.HasSuffix(path, "/")) {
+		// nginx replaces path with alias literally
+		ret += "/"
+	}
+	return ret
+}
+
+// tryFiles returns arguments of try_files directive, empty if not needed
+func (s *Static) tryFiles(match, path string) string {
+	if !s.SPA {
+		return ""
+	}
+
+	index := "index.html"
+	if arr := strings.Fields(s.Index); len(arr) > 0 {
+		index = arr[0]
+	}
+
+	base := "/"
+	if !isRegexMatch(match) && match != MatchExact {
+		base = path[:strings.LastIndex(path, "/")+1]
+	}
+	return "$uri $uri/ " + base + index
+}
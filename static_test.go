@@ -0,0 +1,93 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yeast-static")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "index.html")
+	if err := ioutil.WriteFile(file, []byte("<html></html>"), 0644); err != nil {
+		t.Fatalf("Cannot write index file: %s", err)
+	}
+
+	valid := []*Mapping{
+		{Type: MappingStatic, Static: &Static{Root: dir}},
+		{Type: MappingStatic, Static: &Static{Root: dir, Alias: true, Index: "index.html index.htm", AutoIndex: true, SPA: true}},
+	}
+	for _, m := range valid {
+		if err := m.Validate("/app/"); err != nil {
+			t.Errorf("Valid mapping %#v is rejected: %s", m.Static, err)
+		}
+	}
+
+	invalid := []*Mapping{
+		{Type: MappingStatic},
+		{Type: MappingStatic, Static: &Static{Root: "relative/dir"}},
+		{Type: MappingStatic, Static: &Static{Root: filepath.Join(dir, "missing")}},
+		{Type: MappingStatic, Static: &Static{Root: file}},
+		{Type: MappingStatic, Static: &Static{Root: dir, Index: "index.html;"}},
+		{Type: MappingStatic, Static: &Static{Root: dir, Alias: true}, Match: MatchRegex},
+		{Type: MappingStatic, Static: &Static{Root: dir}, Upstream: "http://a"},
+		{Static: &Static{Root: dir}, Upstream: "http://a"},
+	}
+	for _, m := range invalid {
+		if m.Validate("/app/") == nil {
+			t.Errorf("Invalid mapping %#v is accepted", m.Static)
+		}
+	}
+}
+
+func TestStaticExport(t *testing.T) {
+	expect := `server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 80;
+
+    location /app/ {
+        alias /srv/app/dist/;
+        try_files $uri $uri/ /app/index.html;
+        
+    }
+
+    location /files/ {
+        root /srv/public;
+        index index.htm;
+        autoindex on;
+        
+    }
+
+    location /srv/ {
+        root /;
+        
+    }
+
+}`
+
+	s := NewServer("example.com")
+	s.CreateMapping("/app/", &Mapping{
+		Type:   MappingStatic,
+		Static: &Static{Root: "/srv/app/dist", Alias: true, SPA: true},
+	})
+	s.CreateMapping("/files/", &Mapping{
+		Type:   MappingStatic,
+		Static: &Static{Root: "/srv/public/", Index: "index.htm", AutoIndex: true},
+	})
+	s.CreateMapping("/srv/", &Mapping{
+		Type:   MappingStatic,
+		Static: &Static{Root: "/"},
+	})
+	if actual := s.Export(); actual != expect {
+		t.Errorf("Static returns %s", actual)
+	}
+}
@@ -0,0 +1,117 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+)
+
+// transport protocols of Stream
+const (
+	StreamTCP = "tcp"
+	StreamUDP = "udp"
+)
+
+// streamName matches valid stream names
+var streamName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Stream forwards connections of a port to upstream, AKA server segment in
+// stream context of nginx
+type Stream struct {
+	Name string `json:"name"`
+	// StreamTCP or StreamUDP, tcp if empty
+	Protocol string `json:"protocol,omitempty"`
+	// address to listen, all addresses if empty
+	Address string `json:"address,omitempty"`
+	Port    int    `json:"port"`
+	// "host:port" or "unix:/path"
+	Upstream string `json:"upstream"`
+
+	// proxy_connect_timeout and proxy_timeout, nginx default if empty
+	ConnectTimeout string `json:"connect_timeout,omitempty"`
+	Timeout        string `json:"timeout,omitempty"`
+
+	// metadata, not used when exporting
+	Description string `json:"description"`
+}
+
+// Validate checks if s is malformed
+func (s *Stream) Validate() error {
+	if !streamName.MatchString(s.Name) {
+		return fmt.Errorf("invalid stream name %q", s.Name)
+	}
+
+	switch s.Protocol {
+	case "", StreamTCP, StreamUDP:
+	default:
+		return fmt.Errorf("unknown stream protocol %s", s.Protocol)
+	}
+
+	if s.Port <= 0 || s.Port > 65535 {
+		return fmt.Errorf("invalid port %d", s.Port)
+	}
+	if s.Address != "" && net.ParseIP(s.Address) == nil {
+		return fmt.Errorf("invalid listen address %s", s.Address)
+	}
+
+	if !isSocketAddress(s.Upstream) {
+		return fmt.Errorf("invalid upstream %q, it should be host:port or unix:/path", s.Upstream)
+	}
+
+	if s.ConnectTimeout != "" && !isTime(s.ConnectTimeout) {
+		return fmt.Errorf("invalid connect timeout %s", s.ConnectTimeout)
+	}
+	if s.Timeout != "" && !isTime(s.Timeout) {
+		return fmt.Errorf("invalid timeout %s", s.Timeout)
+	}
+	return nil
+}
+
+// protocol returns transport protocol of s, tcp if not set
+func (s *Stream) protocol() string {
+	if s.Protocol == "" {
+		return StreamTCP
+	}
+	return s.Protocol
+}
+
+// conflicts reports whether s and o listen on same port
+func (s *Stream) conflicts(o *Stream) bool {
+	return s.Port == o.Port && s.protocol() == o.protocol()
+}
+
+// Listen returns address argument of listen directive
+func (s *Stream) Listen() string {
+	if s.Address == "" {
+		return strconv.Itoa(s.Port)
+	}
+	return net.JoinHostPort(s.Address, strconv.Itoa(s.Port))
+}
+
+// httpPorts returns tcp ports used by s, including companion http server,
+// caller must hold read lock of s
+func (s *NginxServer) httpPorts() []int {
+	var ret []int
+	for _, l := range s.listens() {
+		ret = append(ret, l.Port)
+	}
+	if tls := s.TLS; tls != nil && (tls.Redirect || tls.Source != TLSSourceFile) {
+		ret = append(ret, 80)
+	}
+	return ret
+}
+
+type streamsByPort []*Stream
+
+func (s streamsByPort) Len() int { return len(s) }
+func (s streamsByPort) Less(i, j int) bool {
+	if s[i].Port != s[j].Port {
+		return s[i].Port < s[j].Port
+	}
+	return s[i].protocol() < s[j].protocol()
+}
+func (s streamsByPort) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
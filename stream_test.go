@@ -0,0 +1,157 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestStreamValidate(t *testing.T) {
+	valid := []*Stream{
+		{Name: "postgres", Port: 5432, Upstream: "db.dev:5432"},
+		{Name: "dns", Protocol: StreamUDP, Address: "127.0.0.1", Port: 53, Upstream: "10.0.0.2:53"},
+		{Name: "redis", Protocol: StreamTCP, Port: 6379, Upstream: "unix:/run/redis.sock", ConnectTimeout: "5s", Timeout: "1h"},
+	}
+	for _, s := range valid {
+		if err := s.Validate(); err != nil {
+			t.Errorf("Valid stream %#v is rejected: %s", s, err)
+		}
+	}
+
+	invalid := []*Stream{
+		{Name: "", Port: 5432, Upstream: "db:5432"},
+		{Name: "a b", Port: 5432, Upstream: "db:5432"},
+		{Name: "pg", Protocol: "sctp", Port: 5432, Upstream: "db:5432"},
+		{Name: "pg", Port: 0, Upstream: "db:5432"},
+		{Name: "pg", Port: 65536, Upstream: "db:5432"},
+		{Name: "pg", Address: "localhost", Port: 5432, Upstream: "db:5432"},
+		{Name: "pg", Port: 5432, Upstream: "db"},
+		{Name: "pg", Port: 5432, Upstream: "tcp://db:5432"},
+		{Name: "pg", Port: 5432, Upstream: "db:5432", Timeout: "1 h"},
+	}
+	for _, s := range invalid {
+		if s.Validate() == nil {
+			t.Errorf("Invalid stream %#v is accepted", s)
+		}
+	}
+}
+
+func TestStreamExport(t *testing.T) {
+	expect := `server {
+    listen 127.0.0.1:53 udp;
+    proxy_pass 10.0.0.2:53;
+}
+
+server {
+    listen 5432;
+    proxy_pass db.dev:5432;
+    proxy_connect_timeout 5s;
+    proxy_timeout 1h;
+}
+
+`
+
+	data := &Dataset{Streams: []*Stream{
+		{Name: "postgres", Port: 5432, Upstream: "db.dev:5432", ConnectTimeout: "5s", Timeout: "1h"},
+		{Name: "dns", Protocol: StreamUDP, Address: "127.0.0.1", Port: 53, Upstream: "10.0.0.2:53"},
+	}}
+	buf := &bytes.Buffer{}
+	if err := defaultRenderer.Streams(buf, data); err != nil {
+		t.Fatalf("Cannot render streams: %s", err)
+	}
+	if actual := buf.String(); actual != expect {
+		t.Errorf("Streams returns %s", actual)
+	}
+}
+
+func TestStreamPersistor(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	pg := &Stream{Name: "postgres", Port: 5432, Upstream: "db.dev:5432"}
+	if err := p.SaveStream(pg); err != ErrNoStreamFile {
+		t.Errorf("Saving stream without stream file should fail, got %v", err)
+	}
+
+	fn := filepath.Join(filepath.Dir(p.filename), "stream")
+	p.SetStreamFile(fn)
+	p.Create("example.com", "/", "http://127.0.0.1", "")
+
+	if err := p.SaveStream(pg); err != nil {
+		t.Fatalf("Cannot save stream: %s", err)
+	}
+	if err := p.SaveStream(&Stream{Name: "pg2", Port: 5432, Upstream: "db2:5432"}); err != ErrPortInUse {
+		t.Errorf("Port used by another stream should be rejected, got %v", err)
+	}
+	if err := p.SaveStream(&Stream{Name: "postgres", Port: 5432, Upstream: "db2:5432"}); err != nil {
+		t.Errorf("Replacing stream should not conflict with itself: %s", err)
+	}
+	if err := p.SaveStream(&Stream{Name: "web", Port: 80, Upstream: "10.0.0.1:80"}); err != ErrPortInUse {
+		t.Errorf("Port used by server should be rejected, got %v", err)
+	}
+	if err := p.SaveStream(&Stream{Name: "quic", Protocol: StreamUDP, Port: 80, Upstream: "10.0.0.1:80"}); err != nil {
+		t.Errorf("Udp stream should not conflict with server: %s", err)
+	}
+
+	if _, err := p.SetListen("example.com", nil, []*Listen{{Port: 5432}}); err != ErrPortInUse {
+		t.Errorf("Listening on port of stream should be rejected, got %v", err)
+	}
+	if srv := p.List()["example.com"]; len(srv.Listens) != 0 {
+		t.Errorf("Listen settings should be kept when rejected, got %#v", srv.Listens)
+	}
+	if srv, err := p.Create("db.local:5432", "/", "http://127.0.0.1", ""); srv != nil || err != ErrPortInUse {
+		t.Errorf("Creating server on port of stream should be rejected, got %v", err)
+	}
+
+	conf, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("Cannot read stream file: %s", err)
+	}
+	if !bytes.Contains(conf, []byte("proxy_pass db2:5432;")) {
+		t.Errorf("Stream file is not written, got %s", conf)
+	}
+
+	loader := NewPersistor(p.filename, p.conffile)
+	if err := loader.Load(); err != nil {
+		t.Fatalf("Cannot load saved data: %s", err)
+	}
+	if actual := loader.Streams(); len(actual) != 2 || actual[0].Name != "quic" || actual[1].Upstream != "db2:5432" {
+		t.Errorf("Streams are not saved, got %#v", actual)
+	}
+
+	if err := p.DeleteStream("redis"); err != ErrNoSuchStream {
+		t.Errorf("Deleting unknown stream should fail, got %v", err)
+	}
+	if err := p.DeleteStream("postgres"); err != nil {
+		t.Errorf("Cannot delete stream: %s", err)
+	}
+	if len(p.Streams()) != 1 {
+		t.Error("Stream is not deleted")
+	}
+}
+
+func TestStreamTLSConflict(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	p.SetStreamFile(filepath.Join(filepath.Dir(p.filename), "stream"))
+	p.Create("example.com", "/", "http://127.0.0.1", "")
+	if _, err := p.SetListen("example.com", nil, []*Listen{{Port: 443}}); err != nil {
+		t.Fatalf("Cannot set listen: %s", err)
+	}
+	if err := p.SaveStream(&Stream{Name: "web", Port: 80, Upstream: "10.0.0.1:80"}); err != nil {
+		t.Fatalf("Cannot save stream: %s", err)
+	}
+
+	conf := &TLSConfig{Certificate: "/etc/ssl/a.crt", Key: "/etc/ssl/a.key", Redirect: true}
+	if _, err := p.SetTLS("example.com", conf); err != ErrPortInUse {
+		t.Errorf("Redirecting on port of stream should be rejected, got %v", err)
+	}
+	if srv := p.List()["example.com"]; srv.TLS != nil {
+		t.Errorf("TLS settings should be kept when rejected, got %#v", srv.TLS)
+	}
+}
@@ -0,0 +1,112 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+)
+
+// validProtocols lists values accepted by ssl_protocols
+var validProtocols = map[string]bool{
+	"SSLv2":   true,
+	"SSLv3":   true,
+	"TLSv1":   true,
+	"TLSv1.1": true,
+	"TLSv1.2": true,
+	"TLSv1.3": true,
+}
+
+// sources of certificates
+const (
+	// TLSSourceFile uses certificate and key files given by user
+	TLSSourceFile = ""
+	// TLSSourceLocal uses certificate issued by LocalCA
+	TLSSourceLocal = "local"
+	// TLSSourceACME uses certificate issued by ACME CA
+	TLSSourceACME = "acme"
+)
+
+// Issuer issues and renews certificates managed by Yeast
+type Issuer interface {
+	// Paths returns where certificate and key of host are stored
+	Paths(host string) (cert, key string)
+	// Valid reports whether certificate of hosts exists, covers all of them
+	// and is not expiring
+	Valid(hosts ...string) bool
+	// Issue issues or renews certificate of hosts, it is stored at Paths of
+	// first host
+	Issue(hosts ...string) (cert, key string, err error)
+}
+
+// challenger is an Issuer which needs nginx to serve challenge responses
+type challenger interface {
+	ChallengeDir() string
+}
+
+// TLSConfig holds https settings of a server
+type TLSConfig struct {
+	// where the certificate comes from, certificate and key are managed by
+	// Yeast unless it is TLSSourceFile
+	Source string `json:"source,omitempty"`
+
+	Certificate string   `json:"certificate"`
+	Key         string   `json:"key"`
+	Protocols   []string `json:"protocols,omitempty"`
+
+	// directory holding ACME challenge responses, set by Yeast
+	Challenge string `json:"challenge,omitempty"`
+
+	// add a server on port 80 redirecting to https
+	Redirect bool `json:"redirect"`
+}
+
+// Validate checks if certificate and key exist and match each other
+func (c *TLSConfig) Validate() error {
+	switch c.Source {
+	case TLSSourceFile:
+		if c.Certificate == "" || c.Key == "" {
+			return fmt.Errorf("you must pass both certificate and key")
+		}
+
+		if _, err := tls.LoadX509KeyPair(c.Certificate, c.Key); err != nil {
+			return fmt.Errorf("invalid certificate or key: %s", err)
+		}
+	case TLSSourceLocal, TLSSourceACME:
+	default:
+		return fmt.Errorf("unknown certificate source %s", c.Source)
+	}
+
+	for _, p := range c.Protocols {
+		if !validProtocols[p] {
+			return fmt.Errorf("unknown ssl protocol %s", p)
+		}
+	}
+
+	return nil
+}
+
+// SetTLS enables https with c, or disables it if c is nil
+func (s *NginxServer) SetTLS(c *TLSConfig) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if c != nil && c.Redirect && s.listensOn(80) {
+		return fmt.Errorf("cannot redirect to https on port 80")
+	}
+
+	old := s.TLS
+	s.TLS = c
+	if addr := s.duplicateListen(); addr != "" {
+		s.TLS = old
+		return fmt.Errorf("listen on %s more than once", addr)
+	}
+	return nil
+}
+
+// port returns first port to listen, caller must hold lock of s
+func (s *NginxServer) port() string {
+	return strconv.Itoa(s.listens()[0].Port)
+}
@@ -0,0 +1,174 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tempKeyPair writes a self-signed certificate and its key into dir
+func tempKeyPair(t *testing.T, dir, name string) (cert, key string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Cannot generate key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("Cannot create certificate: %s", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("Cannot marshal key: %s", err)
+	}
+
+	cert = filepath.Join(dir, name+".crt")
+	key = filepath.Join(dir, name+".key")
+	ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	ioutil.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return
+}
+
+func TestTLSValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key := tempKeyPair(t, dir, "a.example.com")
+	_, otherKey := tempKeyPair(t, dir, "b.example.com")
+
+	if err := (&TLSConfig{Certificate: cert, Key: key}).Validate(); err != nil {
+		t.Errorf("Valid key pair is rejected: %s", err)
+	}
+	if (&TLSConfig{Certificate: cert, Key: otherKey}).Validate() == nil {
+		t.Error("Mismatched key pair should be rejected")
+	}
+	if (&TLSConfig{Certificate: cert, Key: filepath.Join(dir, "none")}).Validate() == nil {
+		t.Error("Missing key should be rejected")
+	}
+	if (&TLSConfig{Certificate: cert, Key: key, Protocols: []string{"TLSv9"}}).Validate() == nil {
+		t.Error("Unknown protocol should be rejected")
+	}
+}
+
+func TestTLSExport(t *testing.T) {
+	expect := `server {
+    server_name example.com;
+    listen 80;
+    return 301 https://$host$request_uri;
+}
+
+server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 443 ssl http2;
+    ssl_certificate /etc/ssl/a.crt;
+    ssl_certificate_key /etc/ssl/a.key;
+    ssl_protocols TLSv1.2 TLSv1.3;
+
+    location /a/ {
+        proxy_pass http://a;
+        include proxy_params;
+        
+    }
+
+}`
+
+	s := NewServer("example.com")
+	s.Create("/a/", "http://a", "")
+	s.SetTLS(&TLSConfig{
+		Certificate: "/etc/ssl/a.crt",
+		Key:         "/etc/ssl/a.key",
+		Protocols:   []string{"TLSv1.2", "TLSv1.3"},
+		Redirect:    true,
+	})
+	actual := s.Export()
+
+	if actual != expect {
+		t.Errorf("TLS returns %s", actual)
+	}
+}
+
+func TestTLSExportCustomPort(t *testing.T) {
+	expect := `server {
+    server_name example.com;
+    listen 80;
+    return 301 https://$host:8443$request_uri;
+}
+
+server {
+    client_max_body_size 250m;
+    server_name example.com;
+    listen 8443 ssl http2;
+    ssl_certificate /etc/ssl/a.crt;
+    ssl_certificate_key /etc/ssl/a.key;
+
+}`
+
+	s := NewServer("example.com:8443")
+	s.SetTLS(&TLSConfig{
+		Certificate: "/etc/ssl/a.crt",
+		Key:         "/etc/ssl/a.key",
+		Redirect:    true,
+	})
+	actual := s.Export()
+
+	if actual != expect {
+		t.Errorf("TLS with custom port returns %s", actual)
+	}
+}
+
+func TestTLSRedirectOnHTTPPort(t *testing.T) {
+	s := NewServer("example.com:80")
+	err := s.SetTLS(&TLSConfig{
+		Certificate: "/etc/ssl/a.crt",
+		Key:         "/etc/ssl/a.key",
+		Redirect:    true,
+	})
+	if err == nil {
+		t.Error("Redirecting to https on port 80 should be rejected")
+	}
+}
+
+func TestTLSPersistor(t *testing.T) {
+	p := cp(t)
+	defer dp(p)
+
+	conf := &TLSConfig{Certificate: "/etc/ssl/a.crt", Key: "/etc/ssl/a.key"}
+	if srv, _ := p.SetTLS("test.server", conf); srv != nil {
+		t.Error("Setting tls of unknown server should return nil")
+	}
+
+	p.Create("test.server", "/test/", "http://upstream", "")
+	if srv, err := p.SetTLS("test.server", conf); srv == nil || err != nil {
+		t.Fatalf("Cannot set tls: %s", err)
+	}
+
+	loader := NewPersistor(p.filename, p.conffile)
+	if err := loader.Load(); err != nil {
+		t.Fatalf("Cannot load saved data: %s", err)
+	}
+	if actual := loader.List()["test.server"].TLS; actual == nil || actual.Certificate != conf.Certificate {
+		t.Errorf("TLS settings are not saved, got %#v", actual)
+	}
+}
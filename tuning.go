@@ -0,0 +1,116 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import "fmt"
+
+// defaultMaxBodySize is client_max_body_size of servers without Tuning
+const defaultMaxBodySize = "250m"
+
+// Tuning is timeouts, buffering and body size of a server or mapping, empty
+// fields are inherited
+type Tuning struct {
+	ConnectTimeout string `json:"connect_timeout,omitempty"`
+	ReadTimeout    string `json:"read_timeout,omitempty"`
+	SendTimeout    string `json:"send_timeout,omitempty"`
+	// proxy_buffering and proxy_request_buffering, inherited if nil
+	Buffering        *bool `json:"buffering,omitempty"`
+	RequestBuffering *bool `json:"request_buffering,omitempty"`
+	// client_max_body_size, "0" disables the check
+	MaxBodySize string `json:"max_body_size,omitempty"`
+}
+
+// Validate checks if t is malformed
+func (t *Tuning) Validate() error {
+	for name, v := range map[string]string{
+		"connect timeout": t.ConnectTimeout,
+		"read timeout":    t.ReadTimeout,
+		"send timeout":    t.SendTimeout,
+	} {
+		if v != "" && !isTime(v) {
+			return fmt.Errorf("invalid %s %s", name, v)
+		}
+	}
+	if t.MaxBodySize != "" && !isSize(t.MaxBodySize) {
+		return fmt.Errorf("invalid max body size %s", t.MaxBodySize)
+	}
+	return nil
+}
+
+// proxied reports whether t has settings only used when proxying
+func (t *Tuning) proxied() bool {
+	return t.ConnectTimeout != "" || t.ReadTimeout != "" || t.SendTimeout != "" ||
+		t.Buffering != nil || t.RequestBuffering != nil
+}
+
+// directives renders t for module like "proxy" or "grpc",
+// client_max_body_size is included if body is set
+func (t *Tuning) directives(body bool, module string) []string {
+	var ret []string
+	if body && t.MaxBodySize != "" {
+		ret = append(ret, "client_max_body_size "+t.MaxBodySize)
+	}
+	if t.ConnectTimeout != "" {
+		ret = append(ret, module+"_connect_timeout "+t.ConnectTimeout)
+	}
+	if t.ReadTimeout != "" {
+		ret = append(ret, module+"_read_timeout "+t.ReadTimeout)
+	}
+	if t.SendTimeout != "" {
+		ret = append(ret, module+"_send_timeout "+t.SendTimeout)
+	}
+	if t.Buffering != nil {
+		ret = append(ret, module+"_buffering "+onOff(*t.Buffering))
+	}
+	if t.RequestBuffering != nil {
+		ret = append(ret, module+"_request_buffering "+onOff(*t.RequestBuffering))
+	}
+	return ret
+}
+
+// inherit returns copy of t, with empty timeouts and buffering filled by
+// parent. Buffering is not inherited by grpc module, which has no such
+// directives.
+func (t *Tuning) inherit(parent *Tuning, module string) *Tuning {
+	ret := &Tuning{}
+	if t != nil {
+		*ret = *t
+	}
+
+	if ret.ConnectTimeout == "" {
+		ret.ConnectTimeout = parent.ConnectTimeout
+	}
+	if ret.ReadTimeout == "" {
+		ret.ReadTimeout = parent.ReadTimeout
+	}
+	if ret.SendTimeout == "" {
+		ret.SendTimeout = parent.SendTimeout
+	}
+	if module == "grpc" {
+		return ret
+	}
+	if ret.Buffering == nil {
+		ret.Buffering = parent.Buffering
+	}
+	if ret.RequestBuffering == nil {
+		ret.RequestBuffering = parent.RequestBuffering
+	}
+	return ret
+}
+
+// SetTuning sets timeouts, buffering and body size of s, or resets them to
+// default if t is nil
+func (s *NginxServer) SetTuning(t *Tuning) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Tuning = t
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
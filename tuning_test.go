@@ -0,0 +1,67 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import "testing"
+
+func TestTuningValidate(t *testing.T) {
+	off := false
+	valid := []*Mapping{
+		{Upstream: "http://a", Tuning: &Tuning{ConnectTimeout: "5s", ReadTimeout: "1h", SendTimeout: "30s", Buffering: &off, MaxBodySize: "1g"}},
+		{Type: MappingResponse, Response: &Response{Status: 204}, Tuning: &Tuning{MaxBodySize: "0"}},
+	}
+	for _, m := range valid {
+		if err := m.Validate("/"); err != nil {
+			t.Errorf("Valid tuning %#v is rejected: %s", m.Tuning, err)
+		}
+	}
+
+	invalid := []*Mapping{
+		{Upstream: "http://a", Tuning: &Tuning{ConnectTimeout: "5 s"}},
+		{Upstream: "http://a", Tuning: &Tuning{SendTimeout: "-1"}},
+		{Upstream: "http://a", Tuning: &Tuning{MaxBodySize: "250mb"}},
+		{Upstream: "http://a", ReadTimeout: "1h", Tuning: &Tuning{ReadTimeout: "2h"}},
+		{Upstream: "http://a", Streaming: true, Tuning: &Tuning{Buffering: &off}},
+		{Type: MappingResponse, Response: &Response{Status: 204}, Tuning: &Tuning{ReadTimeout: "1s"}},
+	}
+	for _, m := range invalid {
+		if m.Validate("/") == nil {
+			t.Errorf("Invalid tuning %#v is accepted", m.Tuning)
+		}
+	}
+}
+
+func TestTuningExport(t *testing.T) {
+	expect := `server {
+    client_max_body_size 10m;
+    server_name example.com;
+    listen 80;
+    proxy_connect_timeout 5s;
+    proxy_read_timeout 30s;
+
+    location /upload/ {
+        proxy_pass http://upload;
+        include proxy_params;
+        client_max_body_size 1g;
+        proxy_read_timeout 10m;
+        proxy_send_timeout 10m;
+        proxy_request_buffering off;
+        
+    }
+
+}`
+
+	off := false
+	s := NewServer("example.com")
+	s.SetTuning(&Tuning{ConnectTimeout: "5s", ReadTimeout: "30s", MaxBodySize: "10m"})
+	s.CreateMapping("/upload/", &Mapping{Upstream: "http://upload", Tuning: &Tuning{
+		ReadTimeout:      "10m",
+		SendTimeout:      "10m",
+		RequestBuffering: &off,
+		MaxBodySize:      "1g",
+	}})
+	if actual := s.Export(); actual != expect {
+		t.Errorf("Tuning returns %s", actual)
+	}
+}
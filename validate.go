@@ -0,0 +1,44 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nginxTime matches time values like "30s" or "1m30s"
+var nginxTime = regexp.MustCompile(`^([0-9]+(ms|s|m|h|d|w|M|y)?)+$`)
+
+// isTime reports whether v is valid nginx time value
+func isTime(v string) bool {
+	return nginxTime.MatchString(v)
+}
+
+// nginxSize matches size values like "1024", "8k" or "250m"
+var nginxSize = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+
+// isSize reports whether v is valid nginx size value
+func isSize(v string) bool {
+	return nginxSize.MatchString(v)
+}
+
+// isSocketAddress reports whether v is "host:port" or "unix:/path"
+func isSocketAddress(v string) bool {
+	if strings.ContainsAny(v, " \t\n;{}\"'") {
+		return false
+	}
+	if strings.HasPrefix(v, "unix:") {
+		return strings.HasPrefix(v, "unix:/") && len(v) > len("unix:/")
+	}
+
+	host, port, err := net.SplitHostPort(v)
+	if err != nil || host == "" || strings.Contains(host, "/") {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 65536
+}
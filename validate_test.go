@@ -0,0 +1,45 @@
+// This file is part of Yeast
+// Yeast is free software: see LICENSE.txt for more details.
+
+package main
+
+import "testing"
+
+func TestIsTime(t *testing.T) {
+	for _, v := range []string{"30", "30s", "500ms", "1m30s", "1h", "2d"} {
+		if !isTime(v) {
+			t.Errorf("%s should be valid time", v)
+		}
+	}
+	for _, v := range []string{"", "s", "30 s", "30sec", "-1s", "1.5s"} {
+		if isTime(v) {
+			t.Errorf("%s should be invalid time", v)
+		}
+	}
+}
+
+func TestIsSize(t *testing.T) {
+	for _, v := range []string{"0", "1024", "8k", "250m", "1G"} {
+		if !isSize(v) {
+			t.Errorf("%s should be valid size", v)
+		}
+	}
+	for _, v := range []string{"", "m", "1.5m", "10mb", "-1", "1 m"} {
+		if isSize(v) {
+			t.Errorf("%s should be invalid size", v)
+		}
+	}
+}
+
+func TestIsSocketAddress(t *testing.T) {
+	for _, v := range []string{"127.0.0.1:9000", "php:9000", "[::1]:3031", "unix:/run/php/php-fpm.sock"} {
+		if !isSocketAddress(v) {
+			t.Errorf("%s should be valid socket address", v)
+		}
+	}
+	for _, v := range []string{"", "php", ":9000", "php:0", "php:http", "php:9000/a", "unix:", "unix:run/php.sock", "http://php:9000", "php:9000;"} {
+		if isSocketAddress(v) {
+			t.Errorf("%s should be invalid socket address", v)
+		}
+	}
+}